@@ -1,31 +1,75 @@
 package ssa
 
-import "github.com/MovingtoMars/nnvm/types"
+import (
+	"fmt"
 
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// Alloc allocates stack memory for one element of its type, or for count elements if count is set.
+// The memory is freed when the function returns.
 type Alloc struct {
 	NameHandler
 	ReferenceHandler
 	BlockHandler
+	OperandHandler
 
-	typ types.Type
+	typ   types.Type
+	count Value // nil for a single element
+	align int   // 0 for the natural alignment of the type
 }
 
-func newAlloc(typ types.Type) *Alloc {
+func newAlloc(typ types.Type, count Value) *Alloc {
 	return &Alloc{
-		typ: typ,
+		typ:   typ,
+		count: count,
 	}
 }
 
 func (v Alloc) String() string {
-	return "alloc " + v.typ.String()
+	return v.format(ValueIdentifier)
+}
+
+func (v Alloc) format(ident func(Value) string) string {
+	str := "alloc " + v.typ.String()
+
+	if v.count != nil {
+		str += ", " + valueString(ident, v.count)
+	}
+
+	if v.align != 0 {
+		str += fmt.Sprintf(", align %d", v.align)
+	}
+
+	return str
 }
 
 func (v Alloc) Type() types.Type {
 	return types.NewPointer(v.typ)
 }
 
-func (_ Alloc) operands() []*Value {
-	return nil
+// IsDynamic returns true if the number of elements is not a literal.
+func (v Alloc) IsDynamic() bool {
+	if v.count == nil {
+		return false
+	}
+
+	_, ok := v.count.(*IntLiteral)
+	return !ok
+}
+
+// Align returns the alignment of the allocation in bytes, or 0 for the natural alignment of the type.
+func (v Alloc) Align() int {
+	return v.align
+}
+
+// SetAlign sets the alignment of the allocation in bytes. It must be 0 or a power of 2.
+func (v *Alloc) SetAlign(align int) {
+	v.align = align
+}
+
+func (v *Alloc) operands() []*Value {
+	return []*Value{&v.count}
 }
 
 func (_ Alloc) IsTerminating() bool {
@@ -0,0 +1,335 @@
+package analysis
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+//go:generate stringer -type=AliasResult
+type AliasResult int
+
+const (
+	NoAlias   AliasResult = iota // the accesses never overlap
+	MayAlias                     // nothing is known
+	MustAlias                    // the accesses start at the same address
+)
+
+// ModRefResult describes how an instruction may access a memory location. It is a bit set.
+type ModRefResult int
+
+const (
+	NoModRef ModRefResult = 0
+	Ref      ModRefResult = 1 << 0 // may read the location
+	Mod      ModRefResult = 1 << 1 // may write the location
+	ModRef   ModRefResult = Ref | Mod
+)
+
+func (v ModRefResult) String() string {
+	switch v {
+	case NoModRef:
+		return "NoModRef"
+	case Ref:
+		return "Ref"
+	case Mod:
+		return "Mod"
+	case ModRef:
+		return "ModRef"
+	default:
+		panic("unim")
+	}
+}
+
+// UnknownSize is used as the size of an access when it is not known.
+const UnknownSize = -1
+
+// AliasAnalysis answers questions about whether memory accesses can overlap.
+// Sizes are in bytes, and are the number of bytes accessed starting at the pointer.
+type AliasAnalysis interface {
+	// Alias returns whether an access of aSize bytes at a may overlap an access of bSize bytes at b.
+	Alias(a ssa.Value, aSize int, b ssa.Value, bSize int) AliasResult
+
+	// ModRef returns whether a Call or Invoke may read or write the size bytes at ptr.
+	ModRef(call ssa.Instruction, ptr ssa.Value, size int) ModRefResult
+}
+
+// DataLayout gives the sizes and field offsets of types on a target.
+type DataLayout interface {
+	TypeStoreSizeInBits(types.Type) int
+	StructFieldOffsetInBits(typ *types.Struct, index int) int
+}
+
+// BasicAliasAnalysis reasons about the objects pointers are derived from: distinct allocs and globals never
+// overlap, an alloc whose address never escapes can only be accessed through pointers derived from it,
+// and accesses to the same object at known, disjoint offsets never overlap.
+type BasicAliasAnalysis struct {
+	layout DataLayout
+
+	escapes map[*ssa.Alloc]bool // cached results of allocEscapes
+}
+
+// NewBasicAliasAnalysis creates an alias analysis using the layout to compute the offsets of GEPs.
+// If layout is nil, offsets are only known for GEPs whose indexes are all zero.
+func NewBasicAliasAnalysis(layout DataLayout) *BasicAliasAnalysis {
+	return &BasicAliasAnalysis{
+		layout:  layout,
+		escapes: make(map[*ssa.Alloc]bool),
+	}
+}
+
+// Returns the value of the int literal, sign extended from its width.
+func signedIntLiteral(lit *ssa.IntLiteral) int64 {
+	return signExtend(lit.LiteralValue().(uint64), lit.Type().(*types.Int).Width())
+}
+
+// Returns the offset in bytes a GEP adds to its pointer, if the indexes are literals.
+func (v BasicAliasAnalysis) gepOffset(gep *ssa.GEP) (int64, bool) {
+	ops := ssa.GetOperands(gep)
+	typ := ops[0].Type()
+	offset := int64(0)
+
+	for _, index := range ops[1:] {
+		lit, ok := index.(*ssa.IntLiteral)
+		if !ok {
+			return 0, false
+		}
+		val := signedIntLiteral(lit)
+
+		var elem types.Type
+		switch styp := typ.(type) {
+		case *types.Pointer:
+			elem = styp.Element()
+		case *types.Array:
+			elem = styp.Element()
+		case *types.Struct:
+			if val != 0 {
+				if v.layout == nil {
+					return 0, false
+				}
+				offset += int64(v.layout.StructFieldOffsetInBits(styp, int(val)) / 8)
+			}
+			typ = styp.Fields()[val]
+			continue
+		default:
+			return 0, false
+		}
+
+		if val != 0 {
+			if v.layout == nil {
+				return 0, false
+			}
+			offset += val * int64(v.layout.TypeStoreSizeInBits(elem)/8)
+		}
+		typ = elem
+	}
+
+	return offset, true
+}
+
+// Follows GEPs and bitcasts back to the value a pointer is derived from. The offset of the pointer from
+// that value is returned if it is known.
+func (v BasicAliasAnalysis) decompose(ptr ssa.Value) (base ssa.Value, offset int64, offsetKnown bool) {
+	offsetKnown = true
+
+	for {
+		switch p := ptr.(type) {
+		case *ssa.GEP:
+			if gepOffset, ok := v.gepOffset(p); ok {
+				offset += gepOffset
+			} else {
+				offsetKnown = false
+			}
+			ptr = ssa.GetOperands(p)[0]
+			continue
+
+		case *ssa.Convert:
+			if p.ConvertType() == ssa.ConvertBitcast {
+				ptr = ssa.GetOperands(p)[0]
+				continue
+			}
+		}
+
+		return ptr, offset, offsetKnown
+	}
+}
+
+// Allocs, globals and functions are distinct objects, which never overlap each other.
+func isIdentifiedObject(val ssa.Value) bool {
+	switch val.(type) {
+	case *ssa.Alloc, *ssa.Global, *ssa.Function:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns true if a pointer derived from the alloc may be stored, passed to or returned from a function,
+// converted to an int or merged with other pointers, after which it could be accessed through an unrelated pointer.
+func (v *BasicAliasAnalysis) allocEscapes(alloc *ssa.Alloc) bool {
+	if escapes, ok := v.escapes[alloc]; ok {
+		return escapes
+	}
+
+	escapes := pointerEscapes(alloc)
+	v.escapes[alloc] = escapes
+	return escapes
+}
+
+func pointerEscapes(ptr ssa.Value) bool {
+	for _, use := range ptr.Uses() {
+		switch user := use.User().(type) {
+		case *ssa.Load, *ssa.ICmp:
+			// doesn't escape
+
+		case *ssa.Store:
+			if use.OperandIndex() != 0 {
+				return true
+			}
+
+		case *ssa.GEP:
+			if use.OperandIndex() != 0 || pointerEscapes(user) {
+				return true
+			}
+
+		case *ssa.Convert:
+			if user.ConvertType() != ssa.ConvertBitcast || pointerEscapes(user) {
+				return true
+			}
+
+		default:
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v *BasicAliasAnalysis) Alias(a ssa.Value, aSize int, b ssa.Value, bSize int) AliasResult {
+	if a == b {
+		return MustAlias
+	}
+
+	aBase, aOffset, aOffsetKnown := v.decompose(a)
+	bBase, bOffset, bOffsetKnown := v.decompose(b)
+
+	if aBase == bBase {
+		if !aOffsetKnown || !bOffsetKnown {
+			return MayAlias
+		}
+
+		if aOffset == bOffset {
+			return MustAlias
+		}
+
+		if aOffset > bOffset {
+			aOffset, bOffset = bOffset, aOffset
+			aSize, bSize = bSize, aSize
+		}
+		if aSize != UnknownSize && aOffset+int64(aSize) <= bOffset {
+			return NoAlias
+		}
+		return MayAlias
+	}
+
+	if isIdentifiedObject(aBase) && isIdentifiedObject(bBase) {
+		return NoAlias
+	}
+
+	// any pointer not derived from an alloc which does not escape cannot point into it
+	if alloc, ok := aBase.(*ssa.Alloc); ok && !v.allocEscapes(alloc) {
+		return NoAlias
+	}
+	if alloc, ok := bBase.(*ssa.Alloc); ok && !v.allocEscapes(alloc) {
+		return NoAlias
+	}
+
+	return MayAlias
+}
+
+// Returns how the function may access memory, from the instructions in its body.
+func functionModRef(fn *ssa.Function) ModRefResult {
+	if fn.IsPrototype() {
+		return ModRef
+	}
+
+	res := NoModRef
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			switch instr.(type) {
+			case *ssa.Load:
+				res |= Ref
+			case *ssa.Store:
+				res |= Mod
+			case *ssa.Call, *ssa.Invoke:
+				return ModRef
+			}
+		}
+	}
+	return res
+}
+
+func (v *BasicAliasAnalysis) ModRef(call ssa.Instruction, ptr ssa.Value, size int) ModRefResult {
+	res := ModRef
+	if callee := calledFunction(call); callee != nil {
+		res = functionModRef(callee)
+	}
+
+	if res == NoModRef {
+		return res
+	}
+
+	// the callee can only access memory through pointers it can get hold of
+	base, _, _ := v.decompose(ptr)
+	if alloc, ok := base.(*ssa.Alloc); ok && !v.allocEscapes(alloc) {
+		return NoModRef
+	}
+
+	return res
+}
+
+// TypeBasedAliasAnalysis assumes that memory is only ever accessed as the type it was last stored as, so accesses
+// through pointers to different scalar types never overlap. Accesses through *i8 may overlap anything, like char
+// in C. It must only be used for modules from frontends which guarantee this. Other queries are passed on to next.
+type TypeBasedAliasAnalysis struct {
+	next AliasAnalysis
+}
+
+func NewTypeBasedAliasAnalysis(next AliasAnalysis) *TypeBasedAliasAnalysis {
+	return &TypeBasedAliasAnalysis{next: next}
+}
+
+// Returns the element type of the pointer, if it is a scalar type other than i8.
+func typeBasedAccessType(ptr ssa.Value) (types.Type, bool) {
+	ptrType, ok := ptr.Type().(*types.Pointer)
+	if !ok {
+		return nil, false
+	}
+
+	switch elem := ptrType.Element().(type) {
+	case *types.Int:
+		return elem, elem.Width() != 8
+	case *types.Float, *types.Pointer:
+		return elem, true
+	default:
+		return nil, false
+	}
+}
+
+func (v TypeBasedAliasAnalysis) Alias(a ssa.Value, aSize int, b ssa.Value, bSize int) AliasResult {
+	aType, aOk := typeBasedAccessType(a)
+	bType, bOk := typeBasedAccessType(b)
+
+	if aOk && bOk && !aType.Equals(bType) {
+		if _, aPtr := aType.(*types.Pointer); aPtr {
+			if _, bPtr := bType.(*types.Pointer); bPtr {
+				return v.next.Alias(a, aSize, b, bSize) // pointers of different types are often stored interchangeably
+			}
+		}
+		return NoAlias
+	}
+
+	return v.next.Alias(a, aSize, b, bSize)
+}
+
+func (v TypeBasedAliasAnalysis) ModRef(call ssa.Instruction, ptr ssa.Value, size int) ModRefResult {
+	return v.next.ModRef(call, ptr, size)
+}
@@ -0,0 +1,58 @@
+package analysis
+
+type bitSet []uint64
+
+func newBitSet(size int) bitSet {
+	return make(bitSet, (size+63)/64)
+}
+
+func (v bitSet) has(i int) bool {
+	return v[i/64]&(1<<uint(i%64)) != 0
+}
+
+func (v bitSet) add(i int) {
+	v[i/64] |= 1 << uint(i%64)
+}
+
+func (v bitSet) copyFrom(w bitSet) {
+	copy(v, w)
+}
+
+// Adds the elements of w, returning true if any were not in the set.
+func (v bitSet) union(w bitSet) bool {
+	changed := false
+	for i := range v {
+		if n := v[i] | w[i]; n != v[i] {
+			v[i] = n
+			changed = true
+		}
+	}
+	return changed
+}
+
+func (v bitSet) subtract(w bitSet) {
+	for i := range v {
+		v[i] &^= w[i]
+	}
+}
+
+func (v bitSet) equals(w bitSet) bool {
+	for i := range v {
+		if v[i] != w[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Calls fn for each element, in increasing order.
+func (v bitSet) forEach(fn func(int)) {
+	for i, word := range v {
+		for bit := 0; word != 0; bit++ {
+			if word&1 != 0 {
+				fn(i*64 + bit)
+			}
+			word >>= 1
+		}
+	}
+}
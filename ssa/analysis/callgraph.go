@@ -0,0 +1,285 @@
+package analysis
+
+import (
+	"io"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+)
+
+// CallGraph records which functions each function in a module may call.
+//
+// Two nodes have no function. The external caller node calls every function with a body, as all functions
+// are visible outside the module. The unknown callee node is called by indirect calls and by prototypes,
+// which may call back into the module, and calls every function whose address is taken.
+type CallGraph struct {
+	nodes           []*CallGraphNode // function nodes, in module order
+	nodeForFunction map[*ssa.Function]*CallGraphNode
+
+	externalCaller *CallGraphNode
+	unknownCallee  *CallGraphNode
+
+	sccs     [][]*CallGraphNode
+	sccIndex map[*CallGraphNode]int
+}
+
+// CallGraphEdge is a call from one node to another.
+type CallGraphEdge struct {
+	Site   ssa.Instruction // the Call or Invoke; nil for edges from or to the external and unknown nodes
+	Callee *CallGraphNode
+}
+
+type CallGraphNode struct {
+	function *ssa.Function // nil for the external caller and unknown callee nodes
+	name     string
+
+	calls   []CallGraphEdge
+	callers []*CallGraphNode
+}
+
+func (v CallGraphNode) Function() *ssa.Function {
+	return v.function
+}
+
+func (v CallGraphNode) String() string {
+	return v.name
+}
+
+// Calls returns an edge for every call made by the node, in the order they appear.
+func (v CallGraphNode) Calls() []CallGraphEdge {
+	return v.calls
+}
+
+// Callees returns the nodes called by the node, without duplicates.
+func (v CallGraphNode) Callees() []*CallGraphNode {
+	var callees []*CallGraphNode
+	seen := make(map[*CallGraphNode]bool)
+	for _, call := range v.calls {
+		if !seen[call.Callee] {
+			seen[call.Callee] = true
+			callees = append(callees, call.Callee)
+		}
+	}
+	return callees
+}
+
+// Callers returns the nodes which call the node, without duplicates.
+func (v CallGraphNode) Callers() []*CallGraphNode {
+	return v.callers
+}
+
+func (v *CallGraphNode) addCall(site ssa.Instruction, callee *CallGraphNode) {
+	isNewCallee := true
+	for _, call := range v.calls {
+		if call.Callee == callee {
+			isNewCallee = false
+			break
+		}
+	}
+
+	v.calls = append(v.calls, CallGraphEdge{Site: site, Callee: callee})
+	if isNewCallee {
+		callee.callers = append(callee.callers, v)
+	}
+}
+
+func NewCallGraph(mod *ssa.Module) *CallGraph {
+	v := &CallGraph{
+		nodeForFunction: make(map[*ssa.Function]*CallGraphNode),
+		externalCaller:  &CallGraphNode{name: "<external caller>"},
+		unknownCallee:   &CallGraphNode{name: "<unknown callee>"},
+	}
+	v.construct(mod)
+	v.findSCCs()
+	return v
+}
+
+// Nodes returns the node of each function, in module order.
+func (v CallGraph) Nodes() []*CallGraphNode {
+	return v.nodes
+}
+
+// nil if unfound
+func (v CallGraph) NodeForFunction(fn *ssa.Function) *CallGraphNode {
+	return v.nodeForFunction[fn]
+}
+
+func (v CallGraph) ExternalCallerNode() *CallGraphNode {
+	return v.externalCaller
+}
+
+func (v CallGraph) UnknownCalleeNode() *CallGraphNode {
+	return v.unknownCallee
+}
+
+// Returns the callee of a Call or Invoke, or nil if the call is indirect.
+func calledFunction(instr ssa.Instruction) *ssa.Function {
+	switch instr.(type) {
+	case *ssa.Call, *ssa.Invoke:
+		fn, _ := ssa.GetOperands(instr)[0].(*ssa.Function)
+		return fn
+	default:
+		panic("calledFunction: not a call")
+	}
+}
+
+// Returns true if the function is used other than as the callee of a call.
+func isAddressTaken(fn *ssa.Function) bool {
+	for _, use := range fn.Uses() {
+		switch use.User().(type) {
+		case *ssa.Call, *ssa.Invoke:
+			if use.OperandIndex() == 0 {
+				continue
+			}
+		}
+		return true
+	}
+	return false
+}
+
+func (v *CallGraph) construct(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		node := &CallGraphNode{function: fn, name: fn.Name()}
+		v.nodes = append(v.nodes, node)
+		v.nodeForFunction[fn] = node
+	}
+
+	for _, node := range v.nodes {
+		fn := node.function
+
+		if fn.IsPrototype() {
+			node.addCall(nil, v.unknownCallee)
+		} else {
+			v.externalCaller.addCall(nil, node)
+		}
+
+		if isAddressTaken(fn) {
+			v.unknownCallee.addCall(nil, node)
+		}
+
+		for _, block := range fn.Blocks() {
+			for _, instr := range block.Instrs() {
+				switch instr.(type) {
+				case *ssa.Call, *ssa.Invoke:
+					if callee := calledFunction(instr); callee != nil {
+						node.addCall(instr, v.nodeForFunction[callee])
+					} else {
+						node.addCall(instr, v.unknownCallee)
+					}
+				}
+			}
+		}
+	}
+}
+
+// Uses Tarjan's algorithm, which finds each SCC after all the SCCs reachable from it.
+func (v *CallGraph) findSCCs() {
+	v.sccIndex = make(map[*CallGraphNode]int)
+
+	index := make(map[*CallGraphNode]int)
+	lowLink := make(map[*CallGraphNode]int)
+	onStack := make(map[*CallGraphNode]bool)
+	var stack []*CallGraphNode
+
+	var visit func(*CallGraphNode)
+	visit = func(node *CallGraphNode) {
+		index[node] = len(index)
+		lowLink[node] = index[node]
+		stack = append(stack, node)
+		onStack[node] = true
+
+		for _, call := range node.calls {
+			callee := call.Callee
+			if _, visited := index[callee]; !visited {
+				visit(callee)
+				if lowLink[callee] < lowLink[node] {
+					lowLink[node] = lowLink[callee]
+				}
+			} else if onStack[callee] && index[callee] < lowLink[node] {
+				lowLink[node] = index[callee]
+			}
+		}
+
+		if lowLink[node] == index[node] {
+			var scc []*CallGraphNode
+			for {
+				top := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				onStack[top] = false
+				v.sccIndex[top] = len(v.sccs)
+				scc = append(scc, top)
+				if top == node {
+					break
+				}
+			}
+
+			// keep the nodes in the order they were visited
+			for i, j := 0, len(scc)-1; i < j; i, j = i+1, j-1 {
+				scc[i], scc[j] = scc[j], scc[i]
+			}
+			v.sccs = append(v.sccs, scc)
+		}
+	}
+
+	visit(v.externalCaller)
+	for _, node := range v.nodes {
+		if _, visited := index[node]; !visited {
+			visit(node)
+		}
+	}
+	if _, visited := index[v.unknownCallee]; !visited {
+		visit(v.unknownCallee)
+	}
+}
+
+// SCCs returns the strongly connected components of the call graph in bottom-up order: every SCC comes after
+// the SCCs it calls. The functions in an SCC all (possibly indirectly) call each other.
+func (v CallGraph) SCCs() [][]*CallGraphNode {
+	return v.sccs
+}
+
+// SCC returns the strongly connected component containing the node.
+func (v CallGraph) SCC(node *CallGraphNode) []*CallGraphNode {
+	return v.sccs[v.sccIndex[node]]
+}
+
+// IsRecursive returns true if the function may call itself, directly or through other functions.
+// Functions which may be called back by a prototype or an indirect call are conservatively recursive
+// if they call a prototype or make an indirect call.
+func (v CallGraph) IsRecursive(fn *ssa.Function) bool {
+	node := v.nodeForFunction[fn]
+	if len(v.SCC(node)) > 1 {
+		return true
+	}
+
+	for _, call := range node.calls {
+		if call.Callee == node {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (v CallGraph) String() string {
+	str := "CallGraph\n[Node: Callees]\n"
+
+	for _, node := range append([]*CallGraphNode{v.externalCaller, v.unknownCallee}, v.nodes...) {
+		names := make([]string, 0, len(node.calls))
+		for _, callee := range node.Callees() {
+			names = append(names, callee.name)
+		}
+		str += node.name + ": " + strings.Join(names, ", ") + "\n"
+	}
+
+	return str
+}
+
+// Warning: will overwrite images.
+// Always uses svg.
+// Requires `dot` command from the graphviz package be available.
+func (v CallGraph) SaveImage(filename string) error {
+	return saveDotImage(filename, func(w io.Writer) error {
+		return v.WriteDot(w, DotOptions{})
+	})
+}
@@ -1,16 +1,14 @@
 package analysis
 
 import (
-	"fmt"
-	"os"
-	"os/exec"
-	"strings"
+	"io"
 
 	"github.com/MovingtoMars/nnvm/ssa"
 )
 
 type CFG struct {
-	nodes []*CFGNode
+	nodes        []*CFGNode
+	nodeForBlock map[*ssa.Block]*CFGNode
 }
 
 func (v CFG) Nodes() []*CFGNode {
@@ -19,60 +17,16 @@ func (v CFG) Nodes() []*CFGNode {
 
 // nil if unfound
 func (v CFG) NodeForBlock(b *ssa.Block) *CFGNode {
-	for _, node := range v.nodes {
-		if node.block == b {
-			return node
-		}
-	}
-	return nil
+	return v.nodeForBlock[b]
 }
 
 // Warning: will overwrite images.
 // Always uses svg.
 // Requires `dot` command from the graphviz package be available.
 func (v CFG) SaveImage(filename string) error {
-	names := make(map[*CFGNode]string, len(v.nodes))
-	i := 0
-	for _, node := range v.nodes {
-		names[node] = fmt.Sprintf("name%d", i)
-		i++
-	}
-
-	contents := ""
-	for _, node := range v.nodes {
-		contents += "  " + names[node] + " -> {"
-		for _, next := range node.Next() {
-			contents += " " + names[next]
-		}
-		contents += " };\n"
-	}
-
-	for node, name := range names {
-		contents += fmt.Sprintf("  %s [label=\"%s\"];\n", name, strings.Replace(node.String(), "\"", "", -1))
-	}
-
-	return saveDotImage(filename, contents)
-}
-
-func saveDotImage(filename, graphContents string) error {
-	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	dotCommand := exec.Command("dot", "-Tsvg")
-	stdin, err := dotCommand.StdinPipe()
-	if err != nil {
-		return err
-	}
-	dotCommand.Stdout = file
-
-	dotCommand.Start()
-	fmt.Fprintf(stdin, "digraph {\n %s }\n", graphContents)
-	stdin.Close()
-
-	return dotCommand.Wait()
+	return saveDotImage(filename, func(w io.Writer) error {
+		return v.WriteDot(w, DotOptions{})
+	})
 }
 
 type CFGNode struct {
@@ -152,6 +106,12 @@ func (v *CFG) construct(fn *ssa.Function) {
 				blocksToNodes[ops[2].(*ssa.Block)],
 			}
 
+		case *ssa.Invoke:
+			node.next = []*CFGNode{
+				blocksToNodes[term.NormalTarget()],
+				blocksToNodes[term.UnwindTarget()],
+			}
+
 		case *ssa.Unreachable, *ssa.Ret:
 			// these lead to nowhere
 
@@ -165,4 +125,5 @@ func (v *CFG) construct(fn *ssa.Function) {
 	}
 
 	v.nodes = nodes
+	v.nodeForBlock = blocksToNodes
 }
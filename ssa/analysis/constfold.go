@@ -0,0 +1,235 @@
+package analysis
+
+import (
+	"math"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// FoldInstr returns the literal the instruction always evaluates to, or nil if it can't be folded.
+// BinOps, ICmps and Converts are folded if their operands are literals, and phis are folded if every incoming
+// value is the same literal. Operations whose result is undefined, such as division by zero, are never folded.
+func FoldInstr(instr ssa.Instruction) ssa.Literal {
+	ops := ssa.GetOperands(instr)
+
+	switch instr := instr.(type) {
+	case *ssa.BinOp:
+		x, xOk := ops[0].(ssa.Literal)
+		y, yOk := ops[1].(ssa.Literal)
+		if xOk && yOk {
+			return FoldBinOp(instr.BinOpType(), x, y)
+		}
+
+	case *ssa.ICmp:
+		x, xOk := ops[0].(ssa.Literal)
+		y, yOk := ops[1].(ssa.Literal)
+		if xOk && yOk {
+			return FoldICmp(instr.Predicate(), x, y)
+		}
+
+	case *ssa.Convert:
+		if x, ok := ops[0].(ssa.Literal); ok {
+			return FoldConvert(instr.ConvertType(), x, instr.Type())
+		}
+
+	case *ssa.Phi:
+		var res ssa.Literal
+		for i := 0; i < instr.NumIncoming(); i++ {
+			val, _ := instr.GetIncoming(i)
+			lit, ok := val.(ssa.Literal)
+			if !ok || (res != nil && !LiteralsEqual(res, lit)) {
+				return nil
+			}
+			res = lit
+		}
+		return res
+	}
+
+	return nil
+}
+
+// LiteralsEqual returns true if the literals are ints or floats with the same type and bits.
+func LiteralsEqual(x, y ssa.Literal) bool {
+	switch x.(type) {
+	case *ssa.IntLiteral, *ssa.FloatLiteral:
+		return x.Type().Equals(y.Type()) && x.LiteralValue() == y.LiteralValue()
+	default:
+		return false
+	}
+}
+
+// Returns the value of an int literal, or false if it isn't one or is wider than 64 bits.
+func intLiteralValue(lit ssa.Literal) (uint64, int, bool) {
+	intLit, ok := lit.(*ssa.IntLiteral)
+	if !ok {
+		return 0, 0, false
+	}
+
+	width := intLit.Type().(*types.Int).Width()
+	if width > 64 {
+		return 0, 0, false
+	}
+	return intLit.LiteralValue().(uint64), width, true
+}
+
+// Returns the value of a float literal. Float32 values are converted to float64 exactly.
+func floatLiteralValue(lit ssa.Literal) (float64, bool) {
+	floatLit, ok := lit.(*ssa.FloatLiteral)
+	if !ok {
+		return 0, false
+	}
+
+	bits := floatLit.LiteralValue().(uint64)
+	switch floatLit.Type().(*types.Float).Type() {
+	case types.Float32:
+		return float64(math.Float32frombits(uint32(bits))), true
+	case types.Float64:
+		return math.Float64frombits(bits), true
+	default:
+		panic("unim")
+	}
+}
+
+// Returns a literal of the float type, rounding the value if the type is float32.
+func newFloatLiteral(typ *types.Float, value float64) *ssa.FloatLiteral {
+	switch typ.Type() {
+	case types.Float32:
+		return ssa.NewFloat32Literal(float32(value))
+	case types.Float64:
+		return ssa.NewFloat64Literal(value)
+	default:
+		panic("unim")
+	}
+}
+
+// FoldBinOp returns the result of the operation on two int or float literals, or nil if it can't be folded.
+func FoldBinOp(op ssa.BinOpType, x, y ssa.Literal) ssa.Literal {
+	switch op {
+	case ssa.BinOpFAdd, ssa.BinOpFSub, ssa.BinOpFMul, ssa.BinOpFDiv, ssa.BinOpFRem:
+		fx, xOk := floatLiteralValue(x)
+		fy, yOk := floatLiteralValue(y)
+		if !xOk || !yOk {
+			return nil
+		}
+
+		// results computed in float64 are correctly rounded to float32, as float64 has more than twice the precision
+		var res float64
+		switch op {
+		case ssa.BinOpFAdd:
+			res = fx + fy
+		case ssa.BinOpFSub:
+			res = fx - fy
+		case ssa.BinOpFMul:
+			res = fx * fy
+		case ssa.BinOpFDiv:
+			res = fx / fy
+		default:
+			res = math.Mod(fx, fy)
+		}
+		return newFloatLiteral(x.Type().(*types.Float), res)
+	}
+
+	ix, width, xOk := intLiteralValue(x)
+	iy, _, yOk := intLiteralValue(y)
+	if !xOk || !yOk {
+		return nil
+	}
+
+	res, ok := foldIntBinOp(op, ix, iy, width)
+	if !ok {
+		return nil
+	}
+	return ssa.NewIntLiteral(res, x.Type().(*types.Int))
+}
+
+// FoldICmp returns the result of comparing two int literals, or nil if it can't be folded.
+func FoldICmp(pred ssa.IntPredicate, x, y ssa.Literal) ssa.Literal {
+	ix, width, xOk := intLiteralValue(x)
+	iy, _, yOk := intLiteralValue(y)
+	if !xOk || !yOk {
+		return nil
+	}
+
+	res := uint64(0)
+	if evalIntPredicate(pred, ix, iy, width) {
+		res = 1
+	}
+	return ssa.NewIntLiteral(res, types.NewInt(1))
+}
+
+// FoldConvert returns the result of converting a literal to the target type, or nil if it can't be folded.
+// Conversions from floats to ints are not folded if the value is out of range of the int.
+func FoldConvert(convertType ssa.ConvertType, x ssa.Literal, target types.Type) ssa.Literal {
+	switch convertType {
+	case ssa.ConvertSExt, ssa.ConvertZExt, ssa.ConvertTrunc:
+		ix, width, ok := intLiteralValue(x)
+		targetInt := target.(*types.Int)
+		if !ok || targetInt.Width() > 64 {
+			return nil
+		}
+
+		if convertType == ssa.ConvertSExt {
+			ix = uint64(signExtend(ix, width))
+		}
+		return ssa.NewIntLiteral(ix, targetInt)
+
+	case ssa.ConvertFExt, ssa.ConvertFTrunc:
+		fx, ok := floatLiteralValue(x)
+		if !ok {
+			return nil
+		}
+		return newFloatLiteral(target.(*types.Float), fx)
+
+	case ssa.ConvertUIToF, ssa.ConvertSIToF:
+		ix, width, ok := intLiteralValue(x)
+		if !ok {
+			return nil
+		}
+
+		// convert directly to the target type, as rounding to float64 first could round differently
+		signed := convertType == ssa.ConvertSIToF
+		switch target.(*types.Float).Type() {
+		case types.Float32:
+			if signed {
+				return ssa.NewFloat32Literal(float32(signExtend(ix, width)))
+			}
+			return ssa.NewFloat32Literal(float32(ix))
+		case types.Float64:
+			if signed {
+				return ssa.NewFloat64Literal(float64(signExtend(ix, width)))
+			}
+			return ssa.NewFloat64Literal(float64(ix))
+		default:
+			panic("unim")
+		}
+
+	case ssa.ConvertFToUI, ssa.ConvertFToSI:
+		fx, ok := floatLiteralValue(x)
+		targetInt := target.(*types.Int)
+		width := targetInt.Width()
+		if !ok || width > 64 || math.IsNaN(fx) {
+			return nil
+		}
+
+		fx = math.Trunc(fx)
+		if convertType == ssa.ConvertFToSI {
+			if fx < -math.Ldexp(1, width-1) || fx >= math.Ldexp(1, width-1) {
+				return nil
+			}
+			return ssa.NewIntLiteral(uint64(int64(fx)), targetInt)
+		}
+
+		if fx < 0 || fx >= math.Ldexp(1, width) {
+			return nil
+		}
+		return ssa.NewIntLiteral(uint64(fx), targetInt)
+
+	case ssa.ConvertBitcast, ssa.ConvertPtrToInt, ssa.ConvertIntToPtr:
+		// there are no pointer literals
+		return nil
+
+	default:
+		panic("unim")
+	}
+}
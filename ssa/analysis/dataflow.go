@@ -0,0 +1,231 @@
+package analysis
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+type DataflowDirection int
+
+const (
+	Forward  DataflowDirection = iota // facts flow from the entry block along CFG edges
+	Backward                          // facts flow from the exit blocks against CFG edges
+)
+
+// DataflowFact is an element of the lattice of a dataflow problem. The solver never modifies facts, so
+// problems must not modify the facts passed to them either, and should return new facts instead.
+type DataflowFact interface{}
+
+// DataflowProblem describes a dataflow analysis to be solved by SolveDataflow.
+type DataflowProblem interface {
+	Direction() DataflowDirection
+
+	// Boundary returns the fact on entry to the entry block for forward problems, or on exit from blocks
+	// with no successors for backward problems.
+	Boundary() DataflowFact
+
+	// Initial returns the fact every other point starts with. For the solution to be the maximal fixpoint,
+	// this should be the top of the lattice, which is the identity of Meet.
+	Initial() DataflowFact
+
+	// Meet combines the facts flowing into a block from several predecessors (or successors, if backward).
+	Meet(a, b DataflowFact) DataflowFact
+
+	Equal(a, b DataflowFact) bool
+
+	// Transfer returns the fact after the instruction given the fact before it, or the fact before it given
+	// the fact after it if backward.
+	Transfer(instr ssa.Instruction, fact DataflowFact) DataflowFact
+}
+
+// EdgeTransferer can optionally be implemented by a DataflowProblem to change the fact flowing along
+// a CFG edge, for example to refine it with the condition of a CondBr. from and to are in CFG order,
+// regardless of the direction of the problem.
+type EdgeTransferer interface {
+	TransferEdge(from, to *ssa.Block, fact DataflowFact) DataflowFact
+}
+
+// DataflowResult holds the solution of a dataflow problem.
+// Blocks unreachable from the entry block have the initial fact.
+type DataflowResult struct {
+	problem DataflowProblem
+	in, out map[*ssa.Block]DataflowFact
+}
+
+// In returns the fact on entry to the block.
+func (v DataflowResult) In(b *ssa.Block) DataflowFact {
+	return v.in[b]
+}
+
+// Out returns the fact on exit from the block.
+func (v DataflowResult) Out(b *ssa.Block) DataflowFact {
+	return v.out[b]
+}
+
+// Before returns the fact just before the instruction. It is recomputed from the block's fact with Transfer.
+func (v DataflowResult) Before(instr ssa.Instruction) DataflowFact {
+	return v.factAt(instr, true)
+}
+
+// After returns the fact just after the instruction. It is recomputed from the block's fact with Transfer.
+func (v DataflowResult) After(instr ssa.Instruction) DataflowFact {
+	return v.factAt(instr, false)
+}
+
+func (v DataflowResult) factAt(target ssa.Instruction, before bool) DataflowFact {
+	block := target.Block()
+	instrs := block.Instrs()
+
+	if v.problem.Direction() == Forward {
+		fact := v.in[block]
+		for _, instr := range instrs {
+			if instr == target && before {
+				return fact
+			}
+			fact = v.problem.Transfer(instr, fact)
+			if instr == target {
+				return fact
+			}
+		}
+	} else {
+		fact := v.out[block]
+		for i := len(instrs) - 1; i >= 0; i-- {
+			instr := instrs[i]
+			if instr == target && !before {
+				return fact
+			}
+			fact = v.problem.Transfer(instr, fact)
+			if instr == target {
+				return fact
+			}
+		}
+	}
+
+	panic("internal error: instruction not found in its block")
+}
+
+// SolveDataflow solves the problem over the CFG by iterating to a fixpoint. Blocks are visited in reverse
+// post-order for forward problems, and post-order for backward problems, and only revisited when the facts
+// flowing into them change.
+func SolveDataflow(cfg *CFG, problem DataflowProblem) *DataflowResult {
+	res := &DataflowResult{
+		problem: problem,
+		in:      make(map[*ssa.Block]DataflowFact, len(cfg.Nodes())),
+		out:     make(map[*ssa.Block]DataflowFact, len(cfg.Nodes())),
+	}
+
+	for _, node := range cfg.Nodes() {
+		res.in[node.block] = problem.Initial()
+		res.out[node.block] = problem.Initial()
+	}
+
+	if len(cfg.Nodes()) == 0 {
+		return res
+	}
+
+	forward := problem.Direction() == Forward
+	edgeTransferer, hasEdgeTransfer := problem.(EdgeTransferer)
+
+	order := cfgPostOrder(cfg)
+	if forward {
+		for i, j := 0, len(order)-1; i < j; i, j = i+1, j-1 {
+			order[i], order[j] = order[j], order[i]
+		}
+	}
+
+	// flowing into a block: predecessors if forward, successors if backward
+	var sources, sinks func(*CFGNode) []*CFGNode
+	var factIn, factOut map[*ssa.Block]DataflowFact
+	if forward {
+		sources, sinks = (*CFGNode).Prev, (*CFGNode).Next
+		factIn, factOut = res.in, res.out
+	} else {
+		sources, sinks = (*CFGNode).Next, (*CFGNode).Prev
+		factIn, factOut = res.out, res.in
+	}
+
+	orderIndex := make(map[*CFGNode]int, len(order))
+	for i, node := range order {
+		orderIndex[node] = i
+	}
+
+	pending := make([]bool, len(order))
+	for i := range pending {
+		pending[i] = true
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for i, node := range order {
+			if !pending[i] {
+				continue
+			}
+			pending[i] = false
+
+			var fact DataflowFact
+			if (forward && i == 0) || (!forward && len(node.Next()) == 0) {
+				fact = problem.Boundary()
+			} else {
+				fact = problem.Initial()
+			}
+
+			for _, source := range sources(node) {
+				if _, ok := orderIndex[source]; !ok {
+					continue // unreachable
+				}
+
+				sourceFact := factOut[source.block]
+				if hasEdgeTransfer {
+					if forward {
+						sourceFact = edgeTransferer.TransferEdge(source.block, node.block, sourceFact)
+					} else {
+						sourceFact = edgeTransferer.TransferEdge(node.block, source.block, sourceFact)
+					}
+				}
+				fact = problem.Meet(fact, sourceFact)
+			}
+			factIn[node.block] = fact
+
+			instrs := node.block.Instrs()
+			if forward {
+				for _, instr := range instrs {
+					fact = problem.Transfer(instr, fact)
+				}
+			} else {
+				for j := len(instrs) - 1; j >= 0; j-- {
+					fact = problem.Transfer(instrs[j], fact)
+				}
+			}
+
+			if !problem.Equal(fact, factOut[node.block]) {
+				factOut[node.block] = fact
+				for _, sink := range sinks(node) {
+					if j, ok := orderIndex[sink]; ok {
+						pending[j] = true
+						changed = true
+					}
+				}
+			}
+		}
+	}
+
+	return res
+}
+
+// Returns the nodes reachable from the entry node in post-order.
+func cfgPostOrder(cfg *CFG) []*CFGNode {
+	var order []*CFGNode
+	visited := make(map[*CFGNode]bool, len(cfg.Nodes()))
+
+	var visit func(*CFGNode)
+	visit = func(node *CFGNode) {
+		visited[node] = true
+		for _, next := range node.Next() {
+			if !visited[next] {
+				visit(next)
+			}
+		}
+		order = append(order, node)
+	}
+	visit(cfg.Nodes()[0])
+
+	return order
+}
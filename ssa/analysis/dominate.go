@@ -1,15 +1,20 @@
 package analysis
 
 import (
-	"fmt"
-	"strings"
+	"io"
 
 	"github.com/MovingtoMars/nnvm/ssa"
 )
 
+// DominatorTree is the dominator tree or post-dominator tree of a block CFG.
+// Nodes unreachable from the root have no parent, and are not the children of any node.
 type DominatorTree struct {
-	nodes []*DominatorTreeNode
-	cfg   *CFG
+	nodes        []*DominatorTreeNode
+	nodeForBlock map[*ssa.Block]*DominatorTreeNode
+	root         *DominatorTreeNode // the entry node, or the virtual exit node of a post-dominator tree
+	cfg          *CFG
+
+	frontiers map[*DominatorTreeNode][]*DominatorTreeNode // computed on first use
 }
 
 func (v DominatorTree) BlockCFG() *CFG {
@@ -22,21 +27,22 @@ func (v DominatorTree) Nodes() []*DominatorTreeNode {
 
 // return nil if unfound
 func (v DominatorTree) NodeForBlock(b *ssa.Block) *DominatorTreeNode {
-	for _, node := range v.nodes {
-		if node.block == b {
-			return node
-		}
-	}
-	return nil
+	return v.nodeForBlock[b]
+}
+
+// Root returns the entry node, or the virtual exit node of a post-dominator tree.
+// Returns nil if the tree is empty.
+func (v DominatorTree) Root() *DominatorTreeNode {
+	return v.root
 }
 
 func (v DominatorTree) String() string {
 	str := "BlockDominatorTree\n[Node: Children]\n"
 
 	for _, node := range v.nodes {
-		str += node.block.Name() + ": "
+		str += node.String() + ": "
 		for i, child := range node.children {
-			str += child.block.Name()
+			str += child.String()
 
 			if i < len(node.children)-1 {
 				str += ", "
@@ -52,48 +58,194 @@ func (v DominatorTree) String() string {
 // Always uses svg.
 // Requires `dot` command from the graphviz package be available.
 func (v DominatorTree) SaveImage(filename string) error {
-	names := make(map[*DominatorTreeNode]string, len(v.nodes))
-	i := 0
-	for _, node := range v.nodes {
-		names[node] = fmt.Sprintf("name%d", i)
-		i++
+	return saveDotImage(filename, func(w io.Writer) error {
+		return v.WriteDot(w, DotOptions{})
+	})
+}
+
+// Returns true if the node is reachable from the root.
+func (v DominatorTree) isReachable(node *DominatorTreeNode) bool {
+	return node.parent != nil || node == v.root
+}
+
+// DominanceFrontier returns the nodes where the dominance of the node ends: the nodes which are not strictly
+// dominated by it, but which have a predecessor it dominates. Nodes unreachable from the root are ignored.
+// For a post-dominator tree, predecessors are the successors in the CFG, so this is the post-dominance frontier.
+func (v *DominatorTree) DominanceFrontier(node *DominatorTreeNode) []*DominatorTreeNode {
+	if v.frontiers == nil {
+		v.computeFrontiers()
 	}
+	return v.frontiers[node]
+}
+
+// Uses the algorithm from "A Simple, Fast Dominance Algorithm" by Cooper, Harvey and Kennedy: a join node
+// is in the frontier of each node on the paths up the tree from its predecessors to its immediate dominator.
+func (v *DominatorTree) computeFrontiers() {
+	v.frontiers = make(map[*DominatorTreeNode][]*DominatorTreeNode)
 
-	contents := ""
 	for _, node := range v.nodes {
-		contents += "  " + names[node] + " -> {"
-		for _, next := range node.children {
-			contents += " " + names[next]
+		if !v.isReachable(node) {
+			continue
+		}
+
+		if len(node.preds) < 2 {
+			continue
+		}
+
+		for _, runner := range node.preds {
+			if !v.isReachable(runner) {
+				continue
+			}
+
+			for ; runner != node.parent; runner = runner.parent {
+				frontier := v.frontiers[runner]
+				if len(frontier) > 0 && frontier[len(frontier)-1] == node {
+					break // already added from another predecessor, and so were the nodes above
+				}
+				v.frontiers[runner] = append(frontier, node)
+			}
 		}
-		contents += " };\n"
 	}
+}
 
-	for node, name := range names {
-		contents += fmt.Sprintf("  %s [label=\"%s\"];\n", name, strings.Replace(node.String(), "\"", "", -1))
+// IteratedDominanceFrontier returns the limit of taking the dominance frontier of the blocks, then the
+// dominance frontier of the blocks together with the result, and so on. These are the blocks which need phis
+// for a value defined in each of the blocks. The blocks are returned in the order of the tree's nodes.
+func (v *DominatorTree) IteratedDominanceFrontier(blocks []*ssa.Block) []*ssa.Block {
+	inResult := make(map[*DominatorTreeNode]bool)
+	queued := make(map[*DominatorTreeNode]bool)
+
+	var work []*DominatorTreeNode
+	for _, block := range blocks {
+		node := v.NodeForBlock(block)
+		if !queued[node] {
+			queued[node] = true
+			work = append(work, node)
+		}
 	}
 
-	return saveDotImage(filename, contents)
+	for len(work) > 0 {
+		node := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for _, frontier := range v.DominanceFrontier(node) {
+			inResult[frontier] = true
+			if !queued[frontier] {
+				queued[frontier] = true
+				work = append(work, frontier)
+			}
+		}
+	}
+
+	var res []*ssa.Block
+	for _, node := range v.nodes {
+		if inResult[node] && node.block != nil {
+			res = append(res, node.block)
+		}
+	}
+	return res
 }
 
 // "strict" refers to strict dominators.
 type DominatorTreeNode struct {
-	parent   *DominatorTreeNode // nil for start node
+	parent   *DominatorTreeNode // nil for the root and unreachable nodes
 	children []*DominatorTreeNode
-	block    *ssa.Block
+	block    *ssa.Block // nil for the virtual exit node
+
+	// The edges of the graph the tree was built from. For a post-dominator tree these are the reverse of the CFG edges.
+	preds, succs []*DominatorTreeNode
+
+	postOrder int // -1 if unreachable from the root
 }
 
 func (v DominatorTreeNode) String() string {
+	if v.block == nil {
+		return "<exit>"
+	}
 	return v.block.Name()
 }
 
 func NewBlockDominatorTree(cfg *CFG) *DominatorTree {
-	v := &DominatorTree{}
-	v.construct(cfg)
-	v.cfg = cfg
+	v := newDominatorTree(cfg)
+
+	for _, cfgNode := range cfg.Nodes() {
+		node := v.nodeForBlock[cfgNode.block]
+		for _, prev := range cfgNode.Prev() {
+			node.preds = append(node.preds, v.nodeForBlock[prev.block])
+		}
+		for _, next := range cfgNode.Next() {
+			node.succs = append(node.succs, v.nodeForBlock[next.block])
+		}
+	}
+
+	if len(v.nodes) > 0 {
+		v.root = v.nodes[0]
+	}
+
+	v.construct()
 	return v
 }
 
-// Returns nil for entry node
+// NewBlockPostDominatorTree creates the post-dominator tree of the CFG, in which a node's ancestors are the nodes
+// found on every path from it to a function exit. A virtual exit node, with a nil block, is the root of the tree and
+// the successor of every block with no successors. Blocks from which no exit can be reached, such as those in
+// infinite loops, are unreachable in the tree.
+func NewBlockPostDominatorTree(cfg *CFG) *DominatorTree {
+	v := newDominatorTree(cfg)
+	if len(v.nodes) == 0 {
+		return v
+	}
+
+	exit := &DominatorTreeNode{}
+	v.root = exit
+
+	for _, cfgNode := range cfg.Nodes() {
+		node := v.nodeForBlock[cfgNode.block]
+		for _, next := range cfgNode.Next() {
+			node.preds = append(node.preds, v.nodeForBlock[next.block])
+		}
+		for _, prev := range cfgNode.Prev() {
+			node.succs = append(node.succs, v.nodeForBlock[prev.block])
+		}
+
+		if len(cfgNode.Next()) == 0 {
+			node.preds = append(node.preds, exit)
+			exit.succs = append(exit.succs, node)
+		}
+	}
+
+	v.nodes = append(v.nodes, exit)
+
+	v.construct()
+	return v
+}
+
+func newDominatorTree(cfg *CFG) *DominatorTree {
+	v := &DominatorTree{
+		cfg:          cfg,
+		nodes:        make([]*DominatorTreeNode, 0, len(cfg.Nodes())+1),
+		nodeForBlock: make(map[*ssa.Block]*DominatorTreeNode, len(cfg.Nodes())),
+	}
+
+	for _, cfgNode := range cfg.Nodes() {
+		node := &DominatorTreeNode{block: cfgNode.block}
+		v.nodes = append(v.nodes, node)
+		v.nodeForBlock[cfgNode.block] = node
+	}
+
+	return v
+}
+
+func (v DominatorTreeNode) Block() *ssa.Block {
+	return v.block
+}
+
+// Returns the nodes immediately dominated by the node.
+func (v DominatorTreeNode) Children() []*DominatorTreeNode {
+	return v.children
+}
+
+// Returns nil for the root and unreachable nodes.
 func (v DominatorTreeNode) ImmediateDominator() *DominatorTreeNode {
 	return v.parent
 }
@@ -125,88 +277,108 @@ func (v *DominatorTreeNode) Dominators(strict bool) []*DominatorTreeNode {
 	return doms
 }
 
-// Returns true if every node from entry to dominatee contains dominator.
-// Non-strict
-func blockDominatesBlock(dominator, dominatee *CFGNode) bool {
-	if dominator == dominatee {
-		return true
+// Uses the iterative algorithm from "A Simple, Fast Dominance Algorithm" by Cooper, Harvey and Kennedy.
+// The immediate dominator of each node is repeatedly set to the nearest common dominator of its processed
+// predecessors, visiting nodes in reverse post-order until nothing changes.
+func (v *DominatorTree) construct() {
+	if v.root == nil {
+		return
 	}
 
-	if len(dominatee.Prev()) == 0 {
-		return false
+	for _, node := range v.nodes {
+		node.postOrder = -1
 	}
 
-	for _, prev := range dominatee.Prev() {
-		if prev == dominatee {
-			continue
-		}
-		if dom := blockDominatesBlock(dominator, prev); !dom {
-			return false
+	postOrder := make([]*DominatorTreeNode, 0, len(v.nodes))
+	visited := make(map[*DominatorTreeNode]bool, len(v.nodes))
+
+	var visit func(*DominatorTreeNode)
+	visit = func(node *DominatorTreeNode) {
+		visited[node] = true
+		for _, succ := range node.succs {
+			if !visited[succ] {
+				visit(succ)
+			}
 		}
+
+		node.postOrder = len(postOrder)
+		postOrder = append(postOrder, node)
 	}
+	visit(v.root)
 
-	return true
-}
+	idoms := make([]*DominatorTreeNode, len(postOrder)) // indexed by post-order number
+	idoms[v.root.postOrder] = v.root
 
-// slow, TODO use faster algorithm
-func (v *DominatorTree) construct(cfg *CFG) {
-	if len(cfg.Nodes()) == 0 {
-		return
+	intersect := func(a, b *DominatorTreeNode) *DominatorTreeNode {
+		for a != b {
+			for a.postOrder < b.postOrder {
+				a = idoms[a.postOrder]
+			}
+			for b.postOrder < a.postOrder {
+				b = idoms[b.postOrder]
+			}
+		}
+		return a
 	}
 
-	blockDomsMap := make(map[*ssa.Block][]*ssa.Block, len(cfg.Nodes()))
-	blockNodeMap := make(map[*ssa.Block]*DominatorTreeNode)
+	for changed := true; changed; {
+		changed = false
 
-	for _, node := range cfg.Nodes() {
-		doms := []*ssa.Block{}
-
-		for _, otherNode := range cfg.Nodes() {
-			if blockDominatesBlock(otherNode, node) {
-				doms = append(doms, otherNode.block)
+		for i := len(postOrder) - 1; i >= 0; i-- {
+			node := postOrder[i]
+			if node == v.root {
+				continue
 			}
-		}
 
-		blockDomsMap[node.block] = doms
-	}
+			var idom *DominatorTreeNode
+			for _, pred := range node.preds {
+				if pred.postOrder < 0 || idoms[pred.postOrder] == nil {
+					continue // unreachable or not yet processed
+				}
+
+				if idom == nil {
+					idom = pred
+				} else {
+					idom = intersect(pred, idom)
+				}
+			}
 
-	for _, node := range cfg.Nodes() {
-		domNode := &DominatorTreeNode{
-			block: node.block,
+			if idoms[i] != idom {
+				idoms[i] = idom
+				changed = true
+			}
 		}
-		blockNodeMap[node.block] = domNode
-		v.nodes = append(v.nodes, domNode)
 	}
 
-	removeBlockDom := func(node, removeDom *ssa.Block) bool {
-		doms := blockDomsMap[node]
-		for i, dom := range doms {
-			if dom == removeDom {
-				copy(doms[i:], doms[i+1:])
-				doms = doms[:len(doms)-1]
-				blockDomsMap[node] = doms
-				return true
-			}
+	for _, node := range v.nodes {
+		if node == v.root || node.postOrder < 0 {
+			continue
 		}
 
-		return false
+		node.parent = idoms[node.postOrder]
+		node.parent.children = append(node.parent.children, node)
 	}
+}
 
-	var do func(*ssa.Block)
-	do = func(root *ssa.Block) {
-		for _, node := range cfg.Nodes() {
-			if removeBlockDom(node.block, root) && len(blockDomsMap[node.block]) == 1 {
-				blockNodeMap[root].children = append(blockNodeMap[root].children, blockNodeMap[node.block])
-				blockNodeMap[node.block].parent = blockNodeMap[root]
-			}
-		}
+// DominatorTreePreorder returns the blocks of the function in a preorder traversal of its dominator tree,
+// so every block appears after all of its dominators. It can be used as an ssa.BlockOrder.
+// Blocks unreachable from the entry block are not included.
+func DominatorTreePreorder(fn *ssa.Function) []*ssa.Block {
+	if fn.IsPrototype() {
+		return nil
+	}
 
-		for _, node := range cfg.Nodes() {
-			block := node.block
-			if len(blockDomsMap[node.block]) == 1 {
-				do(block)
-			}
+	tree := NewBlockDominatorTree(NewBlockCFG(fn))
+	blocks := make([]*ssa.Block, 0, len(tree.nodes))
+
+	var visit func(*DominatorTreeNode)
+	visit = func(node *DominatorTreeNode) {
+		blocks = append(blocks, node.block)
+		for _, child := range node.children {
+			visit(child)
 		}
 	}
+	visit(tree.Root())
 
-	do(cfg.Nodes()[0].block)
+	return blocks
 }
@@ -0,0 +1,147 @@
+package analysis
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// Creates a function with a block for each name, in order, and a parameter to use as a branch condition.
+func newTestFunction(names ...string) (*ssa.Function, map[string]*ssa.Block, ssa.Value) {
+	mod := ssa.NewModule("test")
+	fn := mod.NewFunction(types.NewSignature([]types.Type{types.NewInt(1)}, types.NewVoid(), false), "f")
+
+	blocks := make(map[string]*ssa.Block)
+	for _, name := range names {
+		blocks[name] = fn.AddBlockAtEnd(name)
+	}
+	return fn, blocks, fn.Parameters()[0]
+}
+
+func nodeNames(nodes []*DominatorTreeNode) []string {
+	names := []string{}
+	for _, node := range nodes {
+		names = append(names, node.String())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func blockNames(blocks []*ssa.Block) []string {
+	names := []string{}
+	for _, block := range blocks {
+		names = append(names, block.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkFrontiers(t *testing.T, tree *DominatorTree, blocks map[string]*ssa.Block, expected map[string][]string) {
+	for name, block := range blocks {
+		want := expected[name]
+		if want == nil {
+			want = []string{}
+		}
+		if got := nodeNames(tree.DominanceFrontier(tree.NodeForBlock(block))); !reflect.DeepEqual(got, want) {
+			t.Errorf("DF(%s) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func checkIteratedFrontier(t *testing.T, tree *DominatorTree, blocks map[string]*ssa.Block, of []string, want []string) {
+	var defs []*ssa.Block
+	for _, name := range of {
+		defs = append(defs, blocks[name])
+	}
+	if got := blockNames(tree.IteratedDominanceFrontier(defs)); !reflect.DeepEqual(got, want) {
+		t.Errorf("IDF(%v) = %v, want %v", of, got, want)
+	}
+}
+
+// entry -> a, b -> join
+func TestDominanceFrontierDiamond(t *testing.T) {
+	fn, blocks, cond := newTestFunction("entry", "a", "b", "join")
+	builder := ssa.NewBuilder()
+
+	builder.SetInsertAtBlockEnd(blocks["entry"])
+	builder.CreateCondBr(cond, blocks["a"], blocks["b"])
+	builder.SetInsertAtBlockEnd(blocks["a"])
+	builder.CreateBr(blocks["join"])
+	builder.SetInsertAtBlockEnd(blocks["b"])
+	builder.CreateBr(blocks["join"])
+	builder.SetInsertAtBlockEnd(blocks["join"])
+	builder.CreateRet(nil)
+
+	tree := NewBlockDominatorTree(NewBlockCFG(fn))
+	checkFrontiers(t, tree, blocks, map[string][]string{
+		"a": {"join"},
+		"b": {"join"},
+	})
+	checkIteratedFrontier(t, tree, blocks, []string{"a"}, []string{"join"})
+	checkIteratedFrontier(t, tree, blocks, []string{"a", "b"}, []string{"join"})
+	checkIteratedFrontier(t, tree, blocks, []string{"entry", "join"}, []string{})
+}
+
+// entry -> a, b; a -> b, exit; b -> a. The loop of a and b can be entered at either block.
+func TestDominanceFrontierIrreducibleLoop(t *testing.T) {
+	fn, blocks, cond := newTestFunction("entry", "a", "b", "exit")
+	builder := ssa.NewBuilder()
+
+	builder.SetInsertAtBlockEnd(blocks["entry"])
+	builder.CreateCondBr(cond, blocks["a"], blocks["b"])
+	builder.SetInsertAtBlockEnd(blocks["a"])
+	builder.CreateCondBr(cond, blocks["b"], blocks["exit"])
+	builder.SetInsertAtBlockEnd(blocks["b"])
+	builder.CreateBr(blocks["a"])
+	builder.SetInsertAtBlockEnd(blocks["exit"])
+	builder.CreateRet(nil)
+
+	tree := NewBlockDominatorTree(NewBlockCFG(fn))
+	checkFrontiers(t, tree, blocks, map[string][]string{
+		"a": {"b"},
+		"b": {"a"},
+	})
+	checkIteratedFrontier(t, tree, blocks, []string{"a"}, []string{"a", "b"})
+	checkIteratedFrontier(t, tree, blocks, []string{"b"}, []string{"a", "b"})
+	checkIteratedFrontier(t, tree, blocks, []string{"exit"}, []string{})
+}
+
+// entry -> a, b; a -> ret1, c; b -> c. ret1 and c both return.
+func TestDominanceFrontierMultipleExits(t *testing.T) {
+	fn, blocks, cond := newTestFunction("entry", "a", "b", "c", "ret1")
+	builder := ssa.NewBuilder()
+
+	builder.SetInsertAtBlockEnd(blocks["entry"])
+	builder.CreateCondBr(cond, blocks["a"], blocks["b"])
+	builder.SetInsertAtBlockEnd(blocks["a"])
+	builder.CreateCondBr(cond, blocks["ret1"], blocks["c"])
+	builder.SetInsertAtBlockEnd(blocks["b"])
+	builder.CreateBr(blocks["c"])
+	builder.SetInsertAtBlockEnd(blocks["c"])
+	builder.CreateRet(nil)
+	builder.SetInsertAtBlockEnd(blocks["ret1"])
+	builder.CreateRet(nil)
+
+	cfg := NewBlockCFG(fn)
+
+	tree := NewBlockDominatorTree(cfg)
+	checkFrontiers(t, tree, blocks, map[string][]string{
+		"a": {"c"},
+		"b": {"c"},
+	})
+	checkIteratedFrontier(t, tree, blocks, []string{"ret1"}, []string{})
+	checkIteratedFrontier(t, tree, blocks, []string{"b", "ret1"}, []string{"c"})
+
+	postTree := NewBlockPostDominatorTree(cfg)
+	checkFrontiers(t, postTree, blocks, map[string][]string{
+		"a":    {"entry"},
+		"b":    {"entry"},
+		"c":    {"a", "entry"},
+		"ret1": {"a"},
+	})
+	checkIteratedFrontier(t, postTree, blocks, []string{"ret1"}, []string{"a", "entry"})
+	checkIteratedFrontier(t, postTree, blocks, []string{"b"}, []string{"entry"})
+}
@@ -0,0 +1,260 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+)
+
+// DotOptions controls how graphs are written in the DOT language by the WriteDot methods.
+// The output only depends on the graph and the options, so it can be compared between runs.
+type DotOptions struct {
+	// Instructions labels the node of each block with the block's instructions, using an HTML-like label.
+	// Values are labelled with the names they would be given when printing the module, but are not renamed.
+	// It has no effect on call graphs.
+	Instructions bool
+
+	// HighlightBackEdges draws back edges in bold red. In a CFG, these are the edges to a block which is
+	// still being visited by a depth-first search from the entry block, which are the back edges of the loops
+	// if the CFG is reducible. In a call graph, these are the calls between functions in the same SCC, which
+	// may be recursive. Dominator trees have no back edges.
+	HighlightBackEdges bool
+}
+
+// Returns the name of the node with the index in the graph.
+func dotNodeName(index int) string {
+	return fmt.Sprintf("name%d", index)
+}
+
+// Returns the label as a DOT string, in quotes.
+func dotQuote(label string) string {
+	label = strings.Replace(label, "\\", "\\\\", -1)
+	label = strings.Replace(label, "\"", "\\\"", -1)
+	label = strings.Replace(label, "\n", "\\n", -1)
+	return "\"" + label + "\""
+}
+
+// Returns the names of the parameters, blocks and instructions of the function, numbered in the same way as
+// by Function.UpdateNames.
+func dotValueNames(fn *ssa.Function) map[ssa.Value]string {
+	names := make(map[ssa.Value]string)
+	counts := map[string]int{"": 1}
+
+	add := func(val ssa.Value) {
+		name := val.Name()
+		if num := counts[name]; num > 0 {
+			names[val] = fmt.Sprintf("%s%d", name, num)
+		} else {
+			names[val] = name
+		}
+		counts[name]++
+	}
+
+	for _, par := range fn.Parameters() {
+		add(par)
+	}
+	for _, block := range fn.Blocks() {
+		add(block)
+	}
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			if val, ok := instr.(ssa.Value); ok {
+				add(val)
+			}
+		}
+	}
+
+	return names
+}
+
+// Returns the attributes of the node for a block. names are the names of the values in the block's function,
+// as returned by dotValueNames, and are only used if the instructions are included.
+func dotBlockAttrs(block *ssa.Block, names map[ssa.Value]string, options DotOptions) string {
+	if !options.Instructions {
+		return "label=" + dotQuote(block.Name())
+	}
+
+	ident := func(val ssa.Value) string {
+		if name, ok := names[val]; ok {
+			return "%" + name
+		}
+		return ssa.ValueIdentifier(val) // literals, globals and functions
+	}
+
+	label := "<B>" + html.EscapeString(names[block]) + ":</B><BR ALIGN=\"LEFT\"/>"
+	for _, instr := range block.Instrs() {
+		str := ssa.InstrString(instr, ident)
+		if val, ok := instr.(ssa.Value); ok {
+			str = ident(val) + " = " + str
+		}
+		label += html.EscapeString(str) + "<BR ALIGN=\"LEFT\"/>"
+	}
+
+	return "shape=box, fontname=\"monospace\", label=<" + label + ">"
+}
+
+func dotEdge(buf *bytes.Buffer, from, to string, highlight bool) {
+	if highlight {
+		fmt.Fprintf(buf, "  %s -> %s [color=red, style=bold];\n", from, to)
+	} else {
+		fmt.Fprintf(buf, "  %s -> %s;\n", from, to)
+	}
+}
+
+// Writes the graph with the node and edge statements in buf.
+func writeDot(w io.Writer, buf *bytes.Buffer) error {
+	if _, err := io.WriteString(w, "digraph {\n"); err != nil {
+		return err
+	}
+	if _, err := buf.WriteTo(w); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "}\n")
+	return err
+}
+
+// WriteDot writes the CFG in the DOT language. Nodes and edges are written in block order.
+func (v CFG) WriteDot(w io.Writer, options DotOptions) error {
+	index := make(map[*CFGNode]int, len(v.nodes))
+	for i, node := range v.nodes {
+		index[node] = i
+	}
+
+	var names map[ssa.Value]string
+	if options.Instructions && len(v.nodes) > 0 {
+		names = dotValueNames(v.nodes[0].block.Function())
+	}
+
+	var backEdges map[cfgEdge]bool
+	if options.HighlightBackEdges && len(v.nodes) > 0 {
+		backEdges = v.retreatingEdges()
+	}
+
+	buf := &bytes.Buffer{}
+	for i, node := range v.nodes {
+		fmt.Fprintf(buf, "  %s [%s];\n", dotNodeName(i), dotBlockAttrs(node.block, names, options))
+	}
+	for i, node := range v.nodes {
+		for _, next := range node.next {
+			dotEdge(buf, dotNodeName(i), dotNodeName(index[next]), backEdges[cfgEdge{node, next}])
+		}
+	}
+
+	return writeDot(w, buf)
+}
+
+type cfgEdge struct {
+	from, to *CFGNode
+}
+
+// Returns the edges found by a depth-first search from the entry node which lead to a node still being visited.
+func (v CFG) retreatingEdges() map[cfgEdge]bool {
+	edges := make(map[cfgEdge]bool)
+	visited := make(map[*CFGNode]bool, len(v.nodes))
+	onStack := make(map[*CFGNode]bool)
+
+	var visit func(*CFGNode)
+	visit = func(node *CFGNode) {
+		visited[node] = true
+		onStack[node] = true
+		for _, next := range node.next {
+			if onStack[next] {
+				edges[cfgEdge{node, next}] = true
+			} else if !visited[next] {
+				visit(next)
+			}
+		}
+		onStack[node] = false
+	}
+	visit(v.nodes[0])
+
+	return edges
+}
+
+// WriteDot writes the dominator tree in the DOT language, with an edge from each node to the nodes it
+// immediately dominates. Nodes are written in block order, followed by the virtual exit node of a
+// post-dominator tree.
+func (v DominatorTree) WriteDot(w io.Writer, options DotOptions) error {
+	index := make(map[*DominatorTreeNode]int, len(v.nodes))
+	for i, node := range v.nodes {
+		index[node] = i
+	}
+
+	var names map[ssa.Value]string
+	if options.Instructions && len(v.nodes) > 0 && v.nodes[0].block != nil {
+		names = dotValueNames(v.nodes[0].block.Function())
+	}
+
+	buf := &bytes.Buffer{}
+	for i, node := range v.nodes {
+		if node.block == nil {
+			fmt.Fprintf(buf, "  %s [label=%s];\n", dotNodeName(i), dotQuote(node.String()))
+		} else {
+			fmt.Fprintf(buf, "  %s [%s];\n", dotNodeName(i), dotBlockAttrs(node.block, names, options))
+		}
+	}
+	for i, node := range v.nodes {
+		for _, child := range node.children {
+			dotEdge(buf, dotNodeName(i), dotNodeName(index[child]), false)
+		}
+	}
+
+	return writeDot(w, buf)
+}
+
+// WriteDot writes the call graph in the DOT language, with an edge from each node to each node it calls.
+// The external caller and unknown callee nodes are written first, followed by the functions in module order.
+func (v CallGraph) WriteDot(w io.Writer, options DotOptions) error {
+	nodes := append([]*CallGraphNode{v.externalCaller, v.unknownCallee}, v.nodes...)
+
+	index := make(map[*CallGraphNode]int, len(nodes))
+	for i, node := range nodes {
+		index[node] = i
+	}
+
+	buf := &bytes.Buffer{}
+	for i, node := range nodes {
+		fmt.Fprintf(buf, "  %s [label=%s];\n", dotNodeName(i), dotQuote(node.String()))
+	}
+	for i, node := range nodes {
+		for _, callee := range node.Callees() {
+			highlight := options.HighlightBackEdges && v.sccIndex[node] == v.sccIndex[callee]
+			dotEdge(buf, dotNodeName(i), dotNodeName(index[callee]), highlight)
+		}
+	}
+
+	return writeDot(w, buf)
+}
+
+// Renders the graph written by writeGraph to an svg image with the `dot` command.
+func saveDotImage(filename string, writeGraph func(io.Writer) error) error {
+	file, err := os.OpenFile(filename, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	dotCommand := exec.Command("dot", "-Tsvg")
+	stdin, err := dotCommand.StdinPipe()
+	if err != nil {
+		return err
+	}
+	dotCommand.Stdout = file
+
+	if err := dotCommand.Start(); err != nil {
+		return err
+	}
+	writeErr := writeGraph(stdin)
+	stdin.Close()
+
+	if err := dotCommand.Wait(); err != nil {
+		return err
+	}
+	return writeErr
+}
@@ -0,0 +1,438 @@
+package analysis
+
+import (
+	"math/bits"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// KnownBits records which bits of an integer value are known to be zero or one.
+// Ints wider than 64 bits are not tracked, and have no known bits.
+type KnownBits struct {
+	Width     int
+	Zero, One uint64 // a set bit means the corresponding bit of the value is known to be 0 or 1
+}
+
+func widthMask(width int) uint64 {
+	if width >= 64 {
+		return ^uint64(0)
+	}
+	return (1 << uint(width)) - 1
+}
+
+// Interprets the low width bits of x as a two's complement number.
+func signExtend(x uint64, width int) int64 {
+	if width < 64 && x&(1<<uint(width-1)) != 0 {
+		x |= ^uint64(0) << uint(width)
+	}
+	return int64(x)
+}
+
+func UnknownBits(width int) KnownBits {
+	return KnownBits{Width: width}
+}
+
+func ConstantBits(width int, value uint64) KnownBits {
+	if width > 64 {
+		return UnknownBits(width)
+	}
+	value &= widthMask(width)
+	return KnownBits{Width: width, Zero: ^value & widthMask(width), One: value}
+}
+
+func (v KnownBits) IsConstant() bool {
+	return v.Width <= 64 && v.Zero|v.One == widthMask(v.Width)
+}
+
+// Constant returns the value, if IsConstant is true.
+func (v KnownBits) Constant() uint64 {
+	return v.One
+}
+
+// TrailingZeros returns the number of low bits known to be zero.
+func (v KnownBits) TrailingZeros() int {
+	n := bits.TrailingZeros64(^v.Zero)
+	if n > v.Width {
+		return v.Width
+	}
+	return n
+}
+
+// LeadingZeros returns the number of high bits known to be zero.
+func (v KnownBits) LeadingZeros() int {
+	if v.Width > 64 {
+		return 0
+	}
+	return bits.LeadingZeros64(^v.Zero&widthMask(v.Width)) - (64 - v.Width)
+}
+
+// Returns true if the sign bit is known to be zero.
+func (v KnownBits) isNonNegative() bool {
+	return v.Width <= 64 && v.Zero&(1<<uint(v.Width-1)) != 0
+}
+
+// Intersect returns the bits known in both.
+func (v KnownBits) Intersect(w KnownBits) KnownBits {
+	return KnownBits{Width: v.Width, Zero: v.Zero & w.Zero, One: v.One & w.One}
+}
+
+// String returns the bits from highest to lowest, with ? for unknown bits.
+func (v KnownBits) String() string {
+	if v.Width > 64 {
+		return strings.Repeat("?", v.Width)
+	}
+
+	str := make([]byte, v.Width)
+	for i := 0; i < v.Width; i++ {
+		bit := uint64(1) << uint(v.Width-1-i)
+		switch {
+		case v.Zero&bit != 0:
+			str[i] = '0'
+		case v.One&bit != 0:
+			str[i] = '1'
+		default:
+			str[i] = '?'
+		}
+	}
+	return string(str)
+}
+
+// KnownBitsAnalysis computes the known bits of integer values on demand, caching the results.
+// It is flow-insensitive: the bits are those known wherever the value is available.
+type KnownBitsAnalysis struct {
+	cache      map[ssa.Value]KnownBits
+	inProgress map[*ssa.Phi]bool
+}
+
+func NewKnownBitsAnalysis() *KnownBitsAnalysis {
+	return &KnownBitsAnalysis{
+		cache:      make(map[ssa.Value]KnownBits),
+		inProgress: make(map[*ssa.Phi]bool),
+	}
+}
+
+// KnownBits returns the known bits of an integer value. Panics if the value is not an int.
+func (v *KnownBitsAnalysis) KnownBits(val ssa.Value) KnownBits {
+	width := val.Type().(*types.Int).Width()
+	if width > 64 {
+		return UnknownBits(width)
+	}
+
+	if known, ok := v.cache[val]; ok {
+		return known
+	}
+
+	if phi, ok := val.(*ssa.Phi); ok && v.inProgress[phi] {
+		return UnknownBits(width) // a cycle through phis; not cached, as the phi's result is not known yet
+	}
+
+	known := v.compute(val, width)
+	v.cache[val] = known
+	return known
+}
+
+func (v *KnownBitsAnalysis) compute(val ssa.Value, width int) KnownBits {
+	switch val := val.(type) {
+	case *ssa.IntLiteral:
+		return ConstantBits(width, val.LiteralValue().(uint64))
+
+	case *ssa.BinOp:
+		ops := ssa.GetOperands(val)
+		return knownBitsBinOp(val.BinOpType(), v.KnownBits(ops[0]), v.KnownBits(ops[1]), width)
+
+	case *ssa.Convert:
+		return v.knownBitsConvert(val, width)
+
+	case *ssa.ICmp:
+		ops := ssa.GetOperands(val)
+		a, b := v.KnownBits(ops[0]), v.KnownBits(ops[1])
+		if a.IsConstant() && b.IsConstant() {
+			if evalIntPredicate(val.Predicate(), a.Constant(), b.Constant(), a.Width) {
+				return ConstantBits(1, 1)
+			}
+			return ConstantBits(1, 0)
+		}
+
+		conflict := a.Zero&b.One | a.One&b.Zero
+		if conflict != 0 {
+			switch val.Predicate() {
+			case ssa.IntEQ:
+				return ConstantBits(1, 0)
+			case ssa.IntNEQ:
+				return ConstantBits(1, 1)
+			}
+		}
+		return UnknownBits(1)
+
+	case *ssa.Phi:
+		v.inProgress[val] = true
+		defer delete(v.inProgress, val)
+
+		known := KnownBits{Width: width, Zero: widthMask(width), One: widthMask(width)}
+		for i := 0; i < val.NumIncoming(); i++ {
+			incoming, _ := val.GetIncoming(i)
+			if incoming == val {
+				continue
+			}
+			known = known.Intersect(v.KnownBits(incoming))
+		}
+		if known.Zero&known.One != 0 {
+			return UnknownBits(width) // no incoming values
+		}
+		return known
+
+	default:
+		// Parameters, loads, calls and so on
+		return UnknownBits(width)
+	}
+}
+
+func (v *KnownBitsAnalysis) knownBitsConvert(conv *ssa.Convert, width int) KnownBits {
+	src := ssa.GetOperands(conv)[0]
+
+	switch conv.ConvertType() {
+	case ssa.ConvertZExt:
+		known := v.KnownBits(src)
+		if known.Width > 64 {
+			return UnknownBits(width)
+		}
+		known.Zero |= widthMask(width) &^ widthMask(known.Width)
+		known.Width = width
+		return known
+
+	case ssa.ConvertSExt:
+		known := v.KnownBits(src)
+		if known.Width > 64 {
+			return UnknownBits(width)
+		}
+		high := widthMask(width) &^ widthMask(known.Width)
+		signBit := uint64(1) << uint(known.Width-1)
+		if known.Zero&signBit != 0 {
+			known.Zero |= high
+		} else if known.One&signBit != 0 {
+			known.One |= high
+		}
+		known.Width = width
+		return known
+
+	case ssa.ConvertTrunc:
+		known := v.KnownBits(src)
+		return KnownBits{Width: width, Zero: known.Zero & widthMask(width), One: known.One & widthMask(width)}
+
+	case ssa.ConvertPtrToInt, ssa.ConvertFToUI, ssa.ConvertFToSI:
+		return UnknownBits(width)
+
+	case ssa.ConvertBitcast, ssa.ConvertFExt, ssa.ConvertFTrunc, ssa.ConvertUIToF, ssa.ConvertSIToF, ssa.ConvertIntToPtr:
+		panic("internal error: convert does not produce an int")
+
+	default:
+		panic("unim")
+	}
+}
+
+// Computes the known bits of x + y + carry, following LLVM's KnownBits::computeForAddCarry.
+func knownBitsAddCarry(x, y KnownBits, carryZero, carryOne bool) KnownBits {
+	mask := widthMask(x.Width)
+
+	carryIn := uint64(0)
+	if !carryZero {
+		carryIn = 1
+	}
+	possibleSumZero := (^x.Zero + ^y.Zero + carryIn) & mask
+
+	carryOneIn := uint64(0)
+	if carryOne {
+		carryOneIn = 1
+	}
+	possibleSumOne := (x.One + y.One + carryOneIn) & mask
+
+	carryKnownZero := ^(possibleSumZero ^ x.Zero ^ y.Zero)
+	carryKnownOne := possibleSumOne ^ x.One ^ y.One
+
+	known := (x.Zero | x.One) & (y.Zero | y.One) & (carryKnownZero | carryKnownOne) & mask
+	return KnownBits{Width: x.Width, Zero: ^possibleSumZero & known, One: possibleSumOne & known}
+}
+
+func knownBitsBinOp(op ssa.BinOpType, x, y KnownBits, width int) KnownBits {
+	if x.IsConstant() && y.IsConstant() {
+		if res, ok := foldIntBinOp(op, x.Constant(), y.Constant(), width); ok {
+			return ConstantBits(width, res)
+		}
+		return UnknownBits(width)
+	}
+
+	mask := widthMask(width)
+
+	switch op {
+	case ssa.BinOpAdd:
+		return knownBitsAddCarry(x, y, true, false)
+
+	case ssa.BinOpSub:
+		notY := KnownBits{Width: width, Zero: y.One, One: y.Zero}
+		return knownBitsAddCarry(x, notY, false, true)
+
+	case ssa.BinOpMul:
+		trailing := x.TrailingZeros() + y.TrailingZeros()
+		if trailing > width {
+			trailing = width
+		}
+		return KnownBits{Width: width, Zero: widthMask(trailing)}
+
+	case ssa.BinOpUDiv:
+		// the quotient is no larger than the dividend
+		return KnownBits{Width: width, Zero: mask &^ widthMask(width-x.LeadingZeros())}
+
+	case ssa.BinOpURem:
+		if y.IsConstant() && y.Constant() != 0 && y.Constant()&(y.Constant()-1) == 0 {
+			low := y.Constant() - 1
+			return KnownBits{Width: width, Zero: x.Zero&low | mask&^low, One: x.One & low}
+		}
+
+		// the remainder is no larger than either operand
+		leading := x.LeadingZeros()
+		if y.LeadingZeros() > leading {
+			leading = y.LeadingZeros()
+		}
+		return KnownBits{Width: width, Zero: mask &^ widthMask(width-leading)}
+
+	case ssa.BinOpSDiv, ssa.BinOpSRem:
+		if x.isNonNegative() && y.isNonNegative() {
+			if op == ssa.BinOpSDiv {
+				return knownBitsBinOp(ssa.BinOpUDiv, x, y, width)
+			}
+			return knownBitsBinOp(ssa.BinOpURem, x, y, width)
+		}
+		return UnknownBits(width)
+
+	case ssa.BinOpShl, ssa.BinOpLShr, ssa.BinOpAShr:
+		if !y.IsConstant() || y.Constant() >= uint64(width) {
+			if op == ssa.BinOpShl {
+				return KnownBits{Width: width, Zero: widthMask(x.TrailingZeros())}
+			}
+			return UnknownBits(width)
+		}
+		shift := uint(y.Constant())
+
+		switch op {
+		case ssa.BinOpShl:
+			return KnownBits{Width: width, Zero: (x.Zero<<shift | widthMask(int(shift))) & mask, One: x.One << shift & mask}
+		case ssa.BinOpLShr:
+			return KnownBits{Width: width, Zero: x.Zero>>shift | mask&^(mask>>shift), One: x.One >> shift}
+		default:
+			zero, one := x.Zero>>shift, x.One>>shift
+			high := mask &^ (mask >> shift)
+			signBit := uint64(1) << uint(width-1)
+			if x.Zero&signBit != 0 {
+				zero |= high
+			} else if x.One&signBit != 0 {
+				one |= high
+			}
+			return KnownBits{Width: width, Zero: zero, One: one}
+		}
+
+	case ssa.BinOpAnd:
+		return KnownBits{Width: width, Zero: x.Zero | y.Zero, One: x.One & y.One}
+
+	case ssa.BinOpOr:
+		return KnownBits{Width: width, Zero: x.Zero & y.Zero, One: x.One | y.One}
+
+	case ssa.BinOpXor:
+		return KnownBits{Width: width, Zero: x.Zero&y.Zero | x.One&y.One, One: x.Zero&y.One | x.One&y.Zero}
+
+	case ssa.BinOpFAdd, ssa.BinOpFSub, ssa.BinOpFMul, ssa.BinOpFDiv, ssa.BinOpFRem:
+		panic("internal error: float binop does not produce an int")
+
+	default:
+		panic("unim")
+	}
+}
+
+// Evaluates an integer binop on constants of the specified width. Returns false if the result is undefined,
+// such as for division by zero, or shifts by at least the width.
+func foldIntBinOp(op ssa.BinOpType, x, y uint64, width int) (uint64, bool) {
+	mask := widthMask(width)
+	sx, sy := signExtend(x, width), signExtend(y, width)
+	minSigned := signExtend(1<<uint(width-1), width)
+
+	var res uint64
+	switch op {
+	case ssa.BinOpAdd:
+		res = x + y
+	case ssa.BinOpSub:
+		res = x - y
+	case ssa.BinOpMul:
+		res = x * y
+	case ssa.BinOpUDiv, ssa.BinOpURem:
+		if y == 0 {
+			return 0, false
+		}
+		if op == ssa.BinOpUDiv {
+			res = x / y
+		} else {
+			res = x % y
+		}
+	case ssa.BinOpSDiv, ssa.BinOpSRem:
+		if sy == 0 || (sx == minSigned && sy == -1) {
+			return 0, false
+		}
+		if op == ssa.BinOpSDiv {
+			res = uint64(sx / sy)
+		} else {
+			res = uint64(sx % sy)
+		}
+	case ssa.BinOpShl, ssa.BinOpLShr, ssa.BinOpAShr:
+		if y >= uint64(width) {
+			return 0, false
+		}
+		switch op {
+		case ssa.BinOpShl:
+			res = x << y
+		case ssa.BinOpLShr:
+			res = x >> y
+		default:
+			res = uint64(sx >> y)
+		}
+	case ssa.BinOpAnd:
+		res = x & y
+	case ssa.BinOpOr:
+		res = x | y
+	case ssa.BinOpXor:
+		res = x ^ y
+	default:
+		return 0, false
+	}
+
+	return res & mask, true
+}
+
+// Evaluates an integer comparison on constants of the specified width.
+func evalIntPredicate(pred ssa.IntPredicate, x, y uint64, width int) bool {
+	sx, sy := signExtend(x, width), signExtend(y, width)
+
+	switch pred {
+	case ssa.IntEQ:
+		return x == y
+	case ssa.IntNEQ:
+		return x != y
+	case ssa.IntUGT:
+		return x > y
+	case ssa.IntUGE:
+		return x >= y
+	case ssa.IntULT:
+		return x < y
+	case ssa.IntULE:
+		return x <= y
+	case ssa.IntSGT:
+		return sx > sy
+	case ssa.IntSGE:
+		return sx >= sy
+	case ssa.IntSLT:
+		return sx < sy
+	case ssa.IntSLE:
+		return sx <= sy
+	default:
+		panic("unim")
+	}
+}
@@ -0,0 +1,329 @@
+package analysis
+
+import (
+	"fmt"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// Liveness holds the values live on entry to and exit from each block of a function, and the live range of
+// each value. Only parameters and instructions which produce a value are tracked; literals, globals and
+// functions are always available.
+//
+// A phi's incoming value is used at the end of the corresponding predecessor, so it is live out of that
+// predecessor but not live into the phi's block (unless it is used there for another reason).
+type Liveness struct {
+	values     []ssa.Value
+	valueIndex map[ssa.Value]int
+	blockIndex map[*ssa.Block]int
+
+	liveIn, liveOut []bitSet
+	ranges          []*LiveRange
+}
+
+// LiveSegment is the part of a live range within one block. Positions are instruction indices:
+// the value is live after the instruction at Start until the instruction at End, which is its last use.
+// Start is -1 if the value is live into the block, and End is the number of instructions in the block if the
+// value is live out of the block. A value which is never used is live from its definition to the next instruction.
+type LiveSegment struct {
+	Block      *ssa.Block
+	Start, End int
+}
+
+// LiveRange is the set of points in a function where a value is live.
+type LiveRange struct {
+	value    ssa.Value
+	segments []LiveSegment
+}
+
+func (v LiveRange) Value() ssa.Value {
+	return v.value
+}
+
+// Segments returns one segment for each block the value is live in, in CFG order.
+func (v LiveRange) Segments() []LiveSegment {
+	return v.segments
+}
+
+func (v LiveRange) String() string {
+	str := ssa.ValueIdentifier(v.value) + ":"
+	for _, seg := range v.segments {
+		str += fmt.Sprintf(" %s(%d, %d)", seg.Block.Name(), seg.Start, seg.End)
+	}
+	return str
+}
+
+// NewLiveness computes the liveness of the values in the function the CFG was built from.
+func NewLiveness(cfg *CFG) *Liveness {
+	v := &Liveness{
+		valueIndex: make(map[ssa.Value]int),
+		blockIndex: make(map[*ssa.Block]int, len(cfg.Nodes())),
+	}
+	v.construct(cfg)
+	return v
+}
+
+func isTrackedValue(val ssa.Value) bool {
+	switch val.(type) {
+	case *ssa.Parameter:
+		return true
+	case ssa.Instruction:
+		_, void := val.Type().(types.Void)
+		return !void
+	default:
+		return false
+	}
+}
+
+func (v *Liveness) addValue(val ssa.Value) {
+	v.valueIndex[val] = len(v.values)
+	v.values = append(v.values, val)
+}
+
+// Returns the index of the value produced by the instruction, if it is tracked.
+func (v *Liveness) instrIndex(instr ssa.Instruction) (int, bool) {
+	val, ok := instr.(ssa.Value)
+	if !ok {
+		return 0, false
+	}
+
+	index, ok := v.valueIndex[val]
+	return index, ok
+}
+
+func (v *Liveness) construct(cfg *CFG) {
+	nodes := cfg.Nodes()
+	if len(nodes) == 0 {
+		return
+	}
+
+	for _, par := range nodes[0].block.Function().Parameters() {
+		v.addValue(par)
+	}
+
+	for i, node := range nodes {
+		v.blockIndex[node.block] = i
+		for _, instr := range node.block.Instrs() {
+			if val, ok := instr.(ssa.Value); ok && isTrackedValue(val) {
+				v.addValue(val)
+			}
+		}
+	}
+
+	defs := make([]bitSet, len(nodes))
+	phiDefs := make([]bitSet, len(nodes))
+	uses := make([]bitSet, len(nodes))    // used before any definition in the block
+	phiUses := make([]bitSet, len(nodes)) // used by phis in successors
+	for i := range nodes {
+		defs[i] = newBitSet(len(v.values))
+		phiDefs[i] = newBitSet(len(v.values))
+		uses[i] = newBitSet(len(v.values))
+		phiUses[i] = newBitSet(len(v.values))
+	}
+
+	for i, node := range nodes {
+		for _, instr := range node.block.Instrs() {
+			if phi, ok := instr.(*ssa.Phi); ok {
+				for j := 0; j < phi.NumIncoming(); j++ {
+					val, pred := phi.GetIncoming(j)
+					if index, ok := v.valueIndex[val]; ok {
+						phiUses[v.blockIndex[pred]].add(index)
+					}
+				}
+			} else {
+				for _, op := range ssa.GetOperands(instr) {
+					if index, ok := v.valueIndex[op]; ok && !defs[i].has(index) {
+						uses[i].add(index)
+					}
+				}
+			}
+
+			if index, ok := v.instrIndex(instr); ok {
+				defs[i].add(index)
+				if _, ok := instr.(*ssa.Phi); ok {
+					phiDefs[i].add(index)
+				}
+			}
+		}
+	}
+
+	v.liveIn = make([]bitSet, len(nodes))
+	v.liveOut = make([]bitSet, len(nodes))
+	for i := range nodes {
+		v.liveIn[i] = newBitSet(len(v.values))
+		v.liveOut[i] = newBitSet(len(v.values))
+	}
+
+	// Iterate backwards through the blocks until nothing changes. Blocks are usually laid out with successors
+	// after their predecessors, so this converges quickly.
+	in := newBitSet(len(v.values))
+	succIn := newBitSet(len(v.values))
+	for changed := true; changed; {
+		changed = false
+
+		for i := len(nodes) - 1; i >= 0; i-- {
+			out := v.liveOut[i]
+			out.union(phiUses[i])
+			for _, next := range nodes[i].Next() {
+				j := v.blockIndex[next.block]
+				succIn.copyFrom(v.liveIn[j])
+				succIn.subtract(phiDefs[j])
+				out.union(succIn)
+			}
+
+			in.copyFrom(out)
+			in.subtract(defs[i])
+			in.union(uses[i])
+			in.union(phiDefs[i])
+
+			if !in.equals(v.liveIn[i]) {
+				v.liveIn[i].copyFrom(in)
+				changed = true
+			}
+		}
+	}
+
+	v.buildRanges(nodes)
+}
+
+func (v *Liveness) buildRanges(nodes []*CFGNode) {
+	v.ranges = make([]*LiveRange, len(v.values))
+	for i, val := range v.values {
+		v.ranges[i] = &LiveRange{value: val}
+	}
+
+	for i, node := range nodes {
+		instrs := node.block.Instrs()
+
+		starts := make(map[int]int) // value index -> segment start
+		ends := make(map[int]int)   // value index -> segment end
+
+		v.liveIn[i].forEach(func(index int) {
+			starts[index] = -1
+		})
+
+		for j, instr := range instrs {
+			if _, ok := instr.(*ssa.Phi); !ok {
+				for _, op := range ssa.GetOperands(instr) {
+					if index, ok := v.valueIndex[op]; ok {
+						ends[index] = j
+					}
+				}
+			}
+
+			if index, ok := v.instrIndex(instr); ok {
+				starts[index] = j
+				ends[index] = j + 1
+			}
+		}
+
+		v.liveOut[i].forEach(func(index int) {
+			ends[index] = len(instrs)
+		})
+
+		// add the segments in value order, so they are deterministic
+		for index := range v.values {
+			start, ok := starts[index]
+			if !ok {
+				continue
+			}
+
+			v.ranges[index].segments = append(v.ranges[index].segments, LiveSegment{
+				Block: node.block,
+				Start: start,
+				End:   ends[index],
+			})
+		}
+	}
+}
+
+func (v Liveness) blockValues(sets []bitSet, b *ssa.Block) []ssa.Value {
+	i, ok := v.blockIndex[b]
+	if !ok {
+		return nil
+	}
+
+	var vals []ssa.Value
+	sets[i].forEach(func(index int) {
+		vals = append(vals, v.values[index])
+	})
+	return vals
+}
+
+// LiveIn returns the values live on entry to the block, including the phis of the block.
+func (v Liveness) LiveIn(b *ssa.Block) []ssa.Value {
+	return v.blockValues(v.liveIn, b)
+}
+
+// LiveOut returns the values live on exit from the block, including those used by phis in its successors.
+func (v Liveness) LiveOut(b *ssa.Block) []ssa.Value {
+	return v.blockValues(v.liveOut, b)
+}
+
+func (v Liveness) IsLiveIn(val ssa.Value, b *ssa.Block) bool {
+	index, ok := v.valueIndex[val]
+	return ok && v.liveIn[v.blockIndex[b]].has(index)
+}
+
+func (v Liveness) IsLiveOut(val ssa.Value, b *ssa.Block) bool {
+	index, ok := v.valueIndex[val]
+	return ok && v.liveOut[v.blockIndex[b]].has(index)
+}
+
+// LiveRange returns the live range of the value, or nil if the value is not tracked.
+func (v Liveness) LiveRange(val ssa.Value) *LiveRange {
+	index, ok := v.valueIndex[val]
+	if !ok {
+		return nil
+	}
+	return v.ranges[index]
+}
+
+// Interfere returns true if the two values are live at the same time somewhere, so they cannot share a
+// register or stack slot. Values which are not tracked never interfere.
+func (v Liveness) Interfere(a, b ssa.Value) bool {
+	if a == b {
+		return false
+	}
+
+	ra, rb := v.LiveRange(a), v.LiveRange(b)
+	if ra == nil || rb == nil {
+		return false
+	}
+
+	for _, sa := range ra.segments {
+		for _, sb := range rb.segments {
+			if sa.Block == sb.Block && max(sa.Start, sb.Start) < min(sa.End, sb.End) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// InterferingValues returns the values which interfere with the value.
+func (v Liveness) InterferingValues(val ssa.Value) []ssa.Value {
+	var res []ssa.Value
+	for _, other := range v.values {
+		if v.Interfere(val, other) {
+			res = append(res, other)
+		}
+	}
+	return res
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,361 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+)
+
+// Loop is a natural loop: the header and the blocks which can reach a back edge to the header without
+// passing through the header. Back edges are edges to a block which dominates their source.
+// Loops sharing a header are merged into one loop.
+type Loop struct {
+	header   *ssa.Block
+	blocks   []*ssa.Block // the header first, then the other blocks in CFG order
+	blockSet map[*ssa.Block]bool
+	latches  []*ssa.Block
+	cfg      *CFG
+
+	parent   *Loop
+	children []*Loop
+	depth    int
+}
+
+func (v Loop) Header() *ssa.Block {
+	return v.header
+}
+
+// Blocks returns the blocks of the loop, including those of its inner loops. The header is first.
+func (v Loop) Blocks() []*ssa.Block {
+	return v.blocks
+}
+
+func (v Loop) Contains(b *ssa.Block) bool {
+	return v.blockSet[b]
+}
+
+// Latches returns the blocks in the loop which branch to the header.
+func (v Loop) Latches() []*ssa.Block {
+	return v.latches
+}
+
+// ExitingBlocks returns the blocks in the loop which have a successor outside of the loop.
+func (v Loop) ExitingBlocks() []*ssa.Block {
+	var exiting []*ssa.Block
+	for _, block := range v.blocks {
+		for _, next := range v.cfg.NodeForBlock(block).Next() {
+			if !v.blockSet[next.block] {
+				exiting = append(exiting, block)
+				break
+			}
+		}
+	}
+	return exiting
+}
+
+// ExitBlocks returns the blocks outside of the loop which are successors of blocks in the loop.
+func (v Loop) ExitBlocks() []*ssa.Block {
+	var exits []*ssa.Block
+	seen := make(map[*ssa.Block]bool)
+	for _, block := range v.blocks {
+		for _, next := range v.cfg.NodeForBlock(block).Next() {
+			if !v.blockSet[next.block] && !seen[next.block] {
+				seen[next.block] = true
+				exits = append(exits, next.block)
+			}
+		}
+	}
+	return exits
+}
+
+// Preheader returns the only predecessor of the header from outside the loop, if that block's only successor
+// is the header. Otherwise returns nil.
+func (v Loop) Preheader() *ssa.Block {
+	var preheader *ssa.Block
+	for _, prev := range v.cfg.NodeForBlock(v.header).Prev() {
+		if v.blockSet[prev.block] {
+			continue
+		}
+
+		if preheader != nil && preheader != prev.block {
+			return nil
+		}
+		preheader = prev.block
+	}
+
+	if preheader == nil {
+		return nil
+	}
+
+	for _, next := range v.cfg.NodeForBlock(preheader).Next() {
+		if next.block != v.header {
+			return nil
+		}
+	}
+
+	return preheader
+}
+
+// Depth returns the nesting depth of the loop. Outermost loops have depth 1.
+func (v Loop) Depth() int {
+	return v.depth
+}
+
+// Returns nil for outermost loops.
+func (v Loop) Parent() *Loop {
+	return v.parent
+}
+
+// Children returns the loops directly nested in the loop.
+func (v Loop) Children() []*Loop {
+	return v.children
+}
+
+func (v Loop) String() string {
+	names := make([]string, len(v.blocks))
+	for i, block := range v.blocks {
+		names[i] = block.Name()
+	}
+	return "loop " + v.header.Name() + ": " + strings.Join(names, ", ")
+}
+
+// LoopInfo holds the loop nesting forest of a function.
+type LoopInfo struct {
+	loops        []*Loop // outer loops before the loops nested in them
+	topLevel     []*Loop
+	loopForBlock map[*ssa.Block]*Loop // innermost loop containing each block
+	irreducible  []*ssa.Block
+}
+
+// NewLoopInfo finds the natural loops of the CFG the dominator tree was built from.
+// The dominator tree must not be a post-dominator tree.
+func NewLoopInfo(domTree *DominatorTree) *LoopInfo {
+	v := &LoopInfo{
+		loopForBlock: make(map[*ssa.Block]*Loop),
+	}
+	v.construct(domTree)
+	return v
+}
+
+// Loops returns every loop, with outer loops before the loops nested in them.
+func (v LoopInfo) Loops() []*Loop {
+	return v.loops
+}
+
+// TopLevelLoops returns the loops which are not nested in other loops.
+func (v LoopInfo) TopLevelLoops() []*Loop {
+	return v.topLevel
+}
+
+// LoopForBlock returns the innermost loop containing the block, or nil if it is not in a loop.
+func (v LoopInfo) LoopForBlock(b *ssa.Block) *Loop {
+	return v.loopForBlock[b]
+}
+
+// LoopDepth returns the number of loops containing the block.
+func (v LoopInfo) LoopDepth(b *ssa.Block) int {
+	if loop := v.loopForBlock[b]; loop != nil {
+		return loop.depth
+	}
+	return 0
+}
+
+func (v LoopInfo) IsLoopHeader(b *ssa.Block) bool {
+	loop := v.loopForBlock[b]
+	return loop != nil && loop.header == b
+}
+
+// IrreducibleEntries returns the targets of edges which close a cycle without being back edges, because the
+// cycle can be entered at more than one block. The blocks of such cycles are not part of any natural loop
+// headed by these blocks.
+func (v LoopInfo) IrreducibleEntries() []*ssa.Block {
+	return v.irreducible
+}
+
+// IsReducible returns true if every cycle in the CFG is a natural loop.
+func (v LoopInfo) IsReducible() bool {
+	return len(v.irreducible) == 0
+}
+
+func (v LoopInfo) String() string {
+	str := "LoopInfo\n"
+
+	for _, loop := range v.loops {
+		str += strings.Repeat("  ", loop.depth-1) + loop.String() + "\n"
+	}
+
+	if len(v.irreducible) > 0 {
+		names := make([]string, len(v.irreducible))
+		for i, block := range v.irreducible {
+			names[i] = block.Name()
+		}
+		str += "irreducible entries: " + strings.Join(names, ", ") + "\n"
+	}
+
+	return str
+}
+
+func (v *LoopInfo) construct(domTree *DominatorTree) {
+	cfg := domTree.BlockCFG()
+	if len(cfg.Nodes()) == 0 {
+		return
+	}
+
+	cfgIndex := make(map[*ssa.Block]int, len(cfg.Nodes()))
+	for i, node := range cfg.Nodes() {
+		cfgIndex[node.block] = i
+	}
+
+	var headers []*ssa.Block
+	loopForHeader := make(map[*ssa.Block]*Loop)
+
+	for _, node := range cfg.Nodes() {
+		domNode := domTree.NodeForBlock(node.block)
+		if !domTree.isReachable(domNode) {
+			continue
+		}
+
+		for _, next := range node.Next() {
+			if !domNode.DominatedBy(domTree.NodeForBlock(next.block), false) {
+				continue
+			}
+
+			loop := loopForHeader[next.block]
+			if loop == nil {
+				loop = &Loop{
+					header:   next.block,
+					blockSet: map[*ssa.Block]bool{next.block: true},
+					cfg:      cfg,
+				}
+				loopForHeader[next.block] = loop
+				headers = append(headers, next.block)
+			}
+
+			if len(loop.latches) == 0 || loop.latches[len(loop.latches)-1] != node.block {
+				loop.latches = append(loop.latches, node.block)
+			}
+		}
+	}
+
+	// find the blocks of each loop by walking backwards from the latches
+	for _, header := range headers {
+		loop := loopForHeader[header]
+
+		var work []*ssa.Block
+		for _, latch := range loop.latches {
+			if !loop.blockSet[latch] {
+				loop.blockSet[latch] = true
+				work = append(work, latch)
+			}
+		}
+
+		for len(work) > 0 {
+			block := work[len(work)-1]
+			work = work[:len(work)-1]
+
+			for _, prev := range cfg.NodeForBlock(block).Prev() {
+				if !loop.blockSet[prev.block] && domTree.isReachable(domTree.NodeForBlock(prev.block)) {
+					loop.blockSet[prev.block] = true
+					work = append(work, prev.block)
+				}
+			}
+		}
+
+		loop.blocks = append(loop.blocks, header)
+		for _, node := range cfg.Nodes() {
+			if node.block != header && loop.blockSet[node.block] {
+				loop.blocks = append(loop.blocks, node.block)
+			}
+		}
+	}
+
+	// Natural loops with different headers are either disjoint or nested, so the parent of a loop is the
+	// smallest other loop containing its header.
+	sort.SliceStable(headers, func(i, j int) bool {
+		return len(loopForHeader[headers[i]].blocks) > len(loopForHeader[headers[j]].blocks)
+	})
+
+	for i, header := range headers {
+		loop := loopForHeader[header]
+
+		for j := i - 1; j >= 0; j-- {
+			if outer := loopForHeader[headers[j]]; outer.blockSet[header] {
+				loop.parent = outer
+				break
+			}
+		}
+
+		for _, block := range loop.blocks {
+			v.loopForBlock[block] = loop // larger loops come first, so this ends up as the innermost loop
+		}
+	}
+
+	byCFGOrder := func(loops []*Loop) {
+		sort.Slice(loops, func(i, j int) bool {
+			return cfgIndex[loops[i].header] < cfgIndex[loops[j].header]
+		})
+	}
+
+	for _, header := range headers {
+		loop := loopForHeader[header]
+		if loop.parent == nil {
+			v.topLevel = append(v.topLevel, loop)
+		} else {
+			loop.parent.children = append(loop.parent.children, loop)
+		}
+	}
+
+	byCFGOrder(v.topLevel)
+
+	var visit func(*Loop, int)
+	visit = func(loop *Loop, depth int) {
+		loop.depth = depth
+		v.loops = append(v.loops, loop)
+
+		byCFGOrder(loop.children)
+		for _, child := range loop.children {
+			visit(child, depth+1)
+		}
+	}
+	for _, loop := range v.topLevel {
+		visit(loop, 1)
+	}
+
+	v.findIrreducible(cfg, domTree)
+}
+
+// An edge to a block on the DFS stack which does not dominate the source of the edge enters a cycle
+// in the middle, so the cycle has more than one entry.
+func (v *LoopInfo) findIrreducible(cfg *CFG, domTree *DominatorTree) {
+	const (
+		unvisited = iota
+		onStack
+		done
+	)
+
+	state := make(map[*CFGNode]int, len(cfg.Nodes()))
+	found := make(map[*ssa.Block]bool)
+
+	var visit func(*CFGNode)
+	visit = func(node *CFGNode) {
+		state[node] = onStack
+
+		for _, next := range node.Next() {
+			switch state[next] {
+			case unvisited:
+				visit(next)
+
+			case onStack:
+				domNode := domTree.NodeForBlock(node.block)
+				if !domNode.DominatedBy(domTree.NodeForBlock(next.block), false) && !found[next.block] {
+					found[next.block] = true
+					v.irreducible = append(v.irreducible, next.block)
+				}
+			}
+		}
+
+		state[node] = done
+	}
+	visit(cfg.Nodes()[0])
+}
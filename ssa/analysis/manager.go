@@ -0,0 +1,165 @@
+package analysis
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+//go:generate stringer -type=AnalysisID
+
+// AnalysisID identifies a kind of analysis cached by an AnalysisManager.
+type AnalysisID int
+
+const (
+	// per-function
+	CFGAnalysis AnalysisID = iota
+	DominatorTreeAnalysis
+	PostDominatorTreeAnalysis
+	LoopInfoAnalysis
+	LivenessAnalysis
+
+	// per-module
+	CallGraphAnalysis
+)
+
+// The analyses each analysis is built from. An analysis is invalidated whenever one it depends on is.
+var analysisDependencies = map[AnalysisID][]AnalysisID{
+	DominatorTreeAnalysis:     {CFGAnalysis},
+	PostDominatorTreeAnalysis: {CFGAnalysis},
+	LoopInfoAnalysis:          {DominatorTreeAnalysis},
+	LivenessAnalysis:          {CFGAnalysis},
+}
+
+// AnalysisManager computes analyses on demand and caches the results until they are invalidated.
+// It must be told whenever the module is modified, by calling Invalidate with the analyses which are still
+// valid, otherwise it will return stale results.
+// An AnalysisManager must not be used by multiple goroutines at once.
+type AnalysisManager struct {
+	functions map[*ssa.Function]map[AnalysisID]interface{}
+	module    map[AnalysisID]interface{}
+}
+
+func NewAnalysisManager() *AnalysisManager {
+	return &AnalysisManager{
+		functions: make(map[*ssa.Function]map[AnalysisID]interface{}),
+		module:    make(map[AnalysisID]interface{}),
+	}
+}
+
+func (v *AnalysisManager) function(fn *ssa.Function, id AnalysisID, compute func() interface{}) interface{} {
+	results, ok := v.functions[fn]
+	if !ok {
+		results = make(map[AnalysisID]interface{})
+		v.functions[fn] = results
+	}
+
+	res, ok := results[id]
+	if !ok {
+		res = compute()
+		results[id] = res
+	}
+	return res
+}
+
+// CFG returns the block CFG of the function, which must not be a prototype.
+func (v *AnalysisManager) CFG(fn *ssa.Function) *CFG {
+	return v.function(fn, CFGAnalysis, func() interface{} {
+		return NewBlockCFG(fn)
+	}).(*CFG)
+}
+
+func (v *AnalysisManager) DominatorTree(fn *ssa.Function) *DominatorTree {
+	return v.function(fn, DominatorTreeAnalysis, func() interface{} {
+		return NewBlockDominatorTree(v.CFG(fn))
+	}).(*DominatorTree)
+}
+
+func (v *AnalysisManager) PostDominatorTree(fn *ssa.Function) *DominatorTree {
+	return v.function(fn, PostDominatorTreeAnalysis, func() interface{} {
+		return NewBlockPostDominatorTree(v.CFG(fn))
+	}).(*DominatorTree)
+}
+
+func (v *AnalysisManager) LoopInfo(fn *ssa.Function) *LoopInfo {
+	return v.function(fn, LoopInfoAnalysis, func() interface{} {
+		return NewLoopInfo(v.DominatorTree(fn))
+	}).(*LoopInfo)
+}
+
+func (v *AnalysisManager) Liveness(fn *ssa.Function) *Liveness {
+	return v.function(fn, LivenessAnalysis, func() interface{} {
+		return NewLiveness(v.CFG(fn))
+	}).(*Liveness)
+}
+
+// CallGraph returns the call graph of the module. The manager must only ever be used with one module.
+func (v *AnalysisManager) CallGraph(mod *ssa.Module) *CallGraph {
+	res, ok := v.module[CallGraphAnalysis]
+	if !ok {
+		res = NewCallGraph(mod)
+		v.module[CallGraphAnalysis] = res
+	}
+	return res.(*CallGraph)
+}
+
+// Returns the set of the preserved analyses which don't depend on an analysis which isn't preserved.
+func validAnalyses(preserved []AnalysisID) map[AnalysisID]bool {
+	valid := make(map[AnalysisID]bool, len(preserved))
+	for _, id := range preserved {
+		valid[id] = true
+	}
+
+	var isValid func(AnalysisID) bool
+	isValid = func(id AnalysisID) bool {
+		if !valid[id] {
+			return false
+		}
+		for _, dep := range analysisDependencies[id] {
+			if !isValid(dep) {
+				return false
+			}
+		}
+		return true
+	}
+
+	res := make(map[AnalysisID]bool, len(preserved))
+	for _, id := range preserved {
+		res[id] = isValid(id)
+	}
+	return res
+}
+
+// Invalidate discards the results of every analysis of the module except the preserved ones, after the
+// module has been modified. Results for functions no longer in the module are always discarded.
+func (v *AnalysisManager) Invalidate(mod *ssa.Module, preserved ...AnalysisID) {
+	valid := validAnalyses(preserved)
+
+	inModule := make(map[*ssa.Function]bool, len(mod.Functions()))
+	for _, fn := range mod.Functions() {
+		inModule[fn] = true
+	}
+
+	for fn, results := range v.functions {
+		if !inModule[fn] {
+			delete(v.functions, fn)
+			continue
+		}
+		invalidateResults(results, valid)
+	}
+	invalidateResults(v.module, valid)
+}
+
+// InvalidateFunction discards the results of every analysis of the function except the preserved ones, after
+// only the function has been modified. Module analyses such as the call graph are also discarded unless preserved.
+func (v *AnalysisManager) InvalidateFunction(fn *ssa.Function, preserved ...AnalysisID) {
+	valid := validAnalyses(preserved)
+	if results, ok := v.functions[fn]; ok {
+		invalidateResults(results, valid)
+	}
+	invalidateResults(v.module, valid)
+}
+
+func invalidateResults(results map[AnalysisID]interface{}, valid map[AnalysisID]bool) {
+	for id := range results {
+		if !valid[id] {
+			delete(results, id)
+		}
+	}
+}
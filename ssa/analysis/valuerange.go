@@ -0,0 +1,721 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"math/big"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// ValueRange is an interval [Lo, Hi] containing every possible value of an integer, interpreted as signed.
+// It is empty if Lo > Hi. Ints wider than 64 bits are not tracked, and always have the full range.
+type ValueRange struct {
+	Width  int
+	Lo, Hi int64
+}
+
+func minSigned(width int) int64 {
+	if width >= 64 {
+		return math.MinInt64
+	}
+	return -1 << uint(width-1)
+}
+
+func maxSigned(width int) int64 {
+	if width >= 64 {
+		return math.MaxInt64
+	}
+	return 1<<uint(width-1) - 1
+}
+
+func FullRange(width int) ValueRange {
+	return ValueRange{Width: width, Lo: minSigned(width), Hi: maxSigned(width)}
+}
+
+func EmptyRange(width int) ValueRange {
+	return ValueRange{Width: width, Lo: 0, Hi: -1}
+}
+
+// ConstantRange returns the range containing only the value, which is sign extended from the width.
+func ConstantRange(width int, value uint64) ValueRange {
+	if width > 64 {
+		return FullRange(width)
+	}
+	x := signExtend(value&widthMask(width), width)
+	return ValueRange{Width: width, Lo: x, Hi: x}
+}
+
+func (v ValueRange) IsEmpty() bool {
+	return v.Lo > v.Hi
+}
+
+func (v ValueRange) IsFull() bool {
+	return v.Lo == minSigned(v.Width) && v.Hi == maxSigned(v.Width)
+}
+
+func (v ValueRange) IsConstant() bool {
+	return v.Lo == v.Hi
+}
+
+func (v ValueRange) IsNonNegative() bool {
+	return !v.IsEmpty() && v.Lo >= 0
+}
+
+func (v ValueRange) Contains(x int64) bool {
+	return v.Lo <= x && x <= v.Hi
+}
+
+func (v ValueRange) Union(w ValueRange) ValueRange {
+	if v.IsEmpty() {
+		return w
+	} else if w.IsEmpty() {
+		return v
+	}
+	return ValueRange{Width: v.Width, Lo: min64(v.Lo, w.Lo), Hi: max64(v.Hi, w.Hi)}
+}
+
+func (v ValueRange) Intersect(w ValueRange) ValueRange {
+	res := ValueRange{Width: v.Width, Lo: max64(v.Lo, w.Lo), Hi: min64(v.Hi, w.Hi)}
+	if res.IsEmpty() {
+		return EmptyRange(v.Width)
+	}
+	return res
+}
+
+func (v ValueRange) String() string {
+	if v.IsEmpty() {
+		return fmt.Sprintf("i%d empty", v.Width)
+	}
+	return fmt.Sprintf("i%d [%d, %d]", v.Width, v.Lo, v.Hi)
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// Returns the range of values with the known bits.
+func rangeFromKnownBits(known KnownBits) ValueRange {
+	if known.Width > 64 {
+		return FullRange(known.Width)
+	}
+
+	signBit := uint64(1) << uint(known.Width-1)
+	mask := widthMask(known.Width)
+
+	switch {
+	case known.Zero&signBit != 0:
+		// the unknown bits are 0 for the minimum and 1 for the maximum
+		return ValueRange{Width: known.Width, Lo: int64(known.One), Hi: int64(^known.Zero & mask)}
+	case known.One&signBit != 0:
+		return ValueRange{Width: known.Width, Lo: signExtend(known.One, known.Width), Hi: signExtend(^known.Zero&mask, known.Width)}
+	default:
+		return FullRange(known.Width)
+	}
+}
+
+// Returns the range [lo, hi], or the full range if either bound doesn't fit in the width.
+func bigRange(width int, lo, hi *big.Int) ValueRange {
+	if lo.Cmp(big.NewInt(minSigned(width))) < 0 || hi.Cmp(big.NewInt(maxSigned(width))) > 0 {
+		return FullRange(width)
+	}
+	return ValueRange{Width: width, Lo: lo.Int64(), Hi: hi.Int64()}
+}
+
+// Returns the range of f applied to each pair of bounds, which is correct for operations monotonic in each operand.
+func boundsRange(x, y ValueRange, f func(a, b *big.Int) *big.Int) ValueRange {
+	var lo, hi *big.Int
+	for _, a := range []int64{x.Lo, x.Hi} {
+		for _, b := range []int64{y.Lo, y.Hi} {
+			res := f(big.NewInt(a), big.NewInt(b))
+			if lo == nil || res.Cmp(lo) < 0 {
+				lo = res
+			}
+			if hi == nil || res.Cmp(hi) > 0 {
+				hi = res
+			}
+		}
+	}
+	return bigRange(x.Width, lo, hi)
+}
+
+// Returns the number of bits needed to represent the non-negative x.
+func bitLength(x int64) int {
+	return big.NewInt(x).BitLen()
+}
+
+func rangeBinOp(op ssa.BinOpType, x, y ValueRange) ValueRange {
+	width := x.Width
+	if x.IsEmpty() || y.IsEmpty() {
+		return EmptyRange(width)
+	}
+	full := FullRange(width)
+
+	switch op {
+	case ssa.BinOpAdd:
+		return boundsRange(x, y, func(a, b *big.Int) *big.Int { return new(big.Int).Add(a, b) })
+
+	case ssa.BinOpSub:
+		return bigRange(width, new(big.Int).Sub(big.NewInt(x.Lo), big.NewInt(y.Hi)), new(big.Int).Sub(big.NewInt(x.Hi), big.NewInt(y.Lo)))
+
+	case ssa.BinOpMul:
+		return boundsRange(x, y, func(a, b *big.Int) *big.Int { return new(big.Int).Mul(a, b) })
+
+	case ssa.BinOpSDiv:
+		if y.Contains(0) || (x.Contains(minSigned(width)) && y.Contains(-1)) {
+			// the magnitude of the quotient is at most that of the dividend
+			if x.Lo == minSigned(width) {
+				return full
+			}
+			m := max64(-x.Lo, x.Hi)
+			return ValueRange{Width: width, Lo: -max64(m, 0), Hi: max64(m, 0)}
+		}
+		return boundsRange(x, y, func(a, b *big.Int) *big.Int { return new(big.Int).Quo(a, b) })
+
+	case ssa.BinOpSRem:
+		if y.Lo == minSigned(width) {
+			return full
+		}
+		// the remainder has the sign of the dividend, and a smaller magnitude than the divisor
+		m := max64(-y.Lo, y.Hi) - 1
+		if m < 0 {
+			return EmptyRange(width) // division by zero
+		}
+		res := ValueRange{Width: width, Lo: -m, Hi: m}
+		if x.Lo >= 0 {
+			res.Lo = 0
+		}
+		if x.Hi <= 0 {
+			res.Hi = 0
+		}
+		if x.Lo > minSigned(width) {
+			mx := max64(-x.Lo, x.Hi)
+			res = res.Intersect(ValueRange{Width: width, Lo: -mx, Hi: mx})
+		}
+		return res
+
+	case ssa.BinOpUDiv:
+		if !x.IsNonNegative() || !y.IsNonNegative() {
+			return full
+		}
+		return ValueRange{Width: width, Lo: x.Lo / max64(y.Hi, 1), Hi: x.Hi / max64(y.Lo, 1)}
+
+	case ssa.BinOpURem:
+		if !y.IsNonNegative() {
+			return full
+		}
+		if y.Hi == 0 {
+			return EmptyRange(width) // division by zero
+		}
+		res := ValueRange{Width: width, Lo: 0, Hi: y.Hi - 1}
+		if x.IsNonNegative() {
+			res.Hi = min64(res.Hi, x.Hi)
+			if x.Hi < y.Lo {
+				res = x // the dividend is always smaller than the divisor
+			}
+		}
+		return res
+
+	case ssa.BinOpShl, ssa.BinOpLShr, ssa.BinOpAShr:
+		if y.Lo < 0 || y.Hi >= int64(width) {
+			return full
+		}
+
+		switch op {
+		case ssa.BinOpShl:
+			return boundsRange(x, y, func(a, b *big.Int) *big.Int { return new(big.Int).Lsh(a, uint(b.Int64())) })
+
+		case ssa.BinOpLShr:
+			if x.IsNonNegative() {
+				return ValueRange{Width: width, Lo: x.Lo >> uint(y.Hi), Hi: x.Hi >> uint(y.Lo)}
+			}
+			if y.Lo == 0 {
+				return full
+			}
+			return ValueRange{Width: width, Lo: 0, Hi: int64(widthMask(width) >> uint(y.Lo))}
+
+		default:
+			return boundsRange(x, y, func(a, b *big.Int) *big.Int { return new(big.Int).Rsh(a, uint(b.Int64())) })
+		}
+
+	case ssa.BinOpAnd:
+		// the result has no bits set which are clear in a non-negative operand
+		switch {
+		case x.IsNonNegative() && y.IsNonNegative():
+			return ValueRange{Width: width, Lo: 0, Hi: min64(x.Hi, y.Hi)}
+		case x.IsNonNegative():
+			return ValueRange{Width: width, Lo: 0, Hi: x.Hi}
+		case y.IsNonNegative():
+			return ValueRange{Width: width, Lo: 0, Hi: y.Hi}
+		}
+		return full
+
+	case ssa.BinOpOr, ssa.BinOpXor:
+		if !x.IsNonNegative() || !y.IsNonNegative() {
+			return full
+		}
+		// the result has no bits set above the highest bit of either operand
+		hi := int64(widthMask(bitLength(max64(x.Hi, y.Hi))))
+		if op == ssa.BinOpOr {
+			return ValueRange{Width: width, Lo: max64(x.Lo, y.Lo), Hi: hi}
+		}
+		return ValueRange{Width: width, Lo: 0, Hi: hi}
+
+	case ssa.BinOpFAdd, ssa.BinOpFSub, ssa.BinOpFMul, ssa.BinOpFDiv, ssa.BinOpFRem:
+		panic("internal error: float binop does not produce an int")
+
+	default:
+		panic("unim")
+	}
+}
+
+// Returns the range of the result of the comparison, which is i1: [-1, 0] when interpreted as signed.
+func rangeICmp(pred ssa.IntPredicate, x, y ValueRange) ValueRange {
+	always, never := ConstantRange(1, 1), ConstantRange(1, 0)
+	if x.IsEmpty() || y.IsEmpty() {
+		return EmptyRange(1)
+	}
+
+	if pred == ssa.IntEQ || pred == ssa.IntNEQ {
+		if x.IsConstant() && y.IsConstant() && x.Lo == y.Lo {
+			if pred == ssa.IntEQ {
+				return always
+			}
+			return never
+		}
+		if x.Intersect(y).IsEmpty() {
+			if pred == ssa.IntEQ {
+				return never
+			}
+			return always
+		}
+		return FullRange(1)
+	}
+
+	if isUnsignedPredicate(pred) {
+		if !x.IsNonNegative() || !y.IsNonNegative() {
+			return FullRange(1)
+		}
+		pred = toSignedPredicate(pred)
+	}
+
+	switch pred {
+	case ssa.IntSLT, ssa.IntSGE:
+		if x.Hi < y.Lo {
+			return boolRange(pred == ssa.IntSLT)
+		} else if x.Lo >= y.Hi {
+			return boolRange(pred == ssa.IntSGE)
+		}
+	case ssa.IntSLE, ssa.IntSGT:
+		if x.Hi <= y.Lo {
+			return boolRange(pred == ssa.IntSLE)
+		} else if x.Lo > y.Hi {
+			return boolRange(pred == ssa.IntSGT)
+		}
+	}
+
+	return FullRange(1)
+}
+
+func boolRange(b bool) ValueRange {
+	if b {
+		return ConstantRange(1, 1)
+	}
+	return ConstantRange(1, 0)
+}
+
+func isUnsignedPredicate(pred ssa.IntPredicate) bool {
+	switch pred {
+	case ssa.IntUGT, ssa.IntUGE, ssa.IntULT, ssa.IntULE:
+		return true
+	default:
+		return false
+	}
+}
+
+// For operands known to be non-negative, unsigned and signed comparisons are the same.
+func toSignedPredicate(pred ssa.IntPredicate) ssa.IntPredicate {
+	switch pred {
+	case ssa.IntUGT:
+		return ssa.IntSGT
+	case ssa.IntUGE:
+		return ssa.IntSGE
+	case ssa.IntULT:
+		return ssa.IntSLT
+	case ssa.IntULE:
+		return ssa.IntSLE
+	default:
+		return pred
+	}
+}
+
+func inversePredicate(pred ssa.IntPredicate) ssa.IntPredicate {
+	switch pred {
+	case ssa.IntEQ:
+		return ssa.IntNEQ
+	case ssa.IntNEQ:
+		return ssa.IntEQ
+	case ssa.IntUGT:
+		return ssa.IntULE
+	case ssa.IntUGE:
+		return ssa.IntULT
+	case ssa.IntULT:
+		return ssa.IntUGE
+	case ssa.IntULE:
+		return ssa.IntUGT
+	case ssa.IntSGT:
+		return ssa.IntSLE
+	case ssa.IntSGE:
+		return ssa.IntSLT
+	case ssa.IntSLT:
+		return ssa.IntSGE
+	case ssa.IntSLE:
+		return ssa.IntSGT
+	default:
+		panic("unim")
+	}
+}
+
+// SwappedPredicate returns the predicate with the operands swapped, so x pred y is equivalent to y swapped x.
+func SwappedPredicate(pred ssa.IntPredicate) ssa.IntPredicate {
+	switch pred {
+	case ssa.IntUGT:
+		return ssa.IntULT
+	case ssa.IntUGE:
+		return ssa.IntULE
+	case ssa.IntULT:
+		return ssa.IntUGT
+	case ssa.IntULE:
+		return ssa.IntUGE
+	case ssa.IntSGT:
+		return ssa.IntSLT
+	case ssa.IntSGE:
+		return ssa.IntSLE
+	case ssa.IntSLT:
+		return ssa.IntSGT
+	case ssa.IntSLE:
+		return ssa.IntSGE
+	default:
+		return pred
+	}
+}
+
+// Returns the range of values x can have if x pred y is true.
+func refineByPredicate(pred ssa.IntPredicate, x, y ValueRange) ValueRange {
+	width := x.Width
+	if y.IsEmpty() {
+		return EmptyRange(width)
+	}
+
+	if isUnsignedPredicate(pred) {
+		switch pred {
+		case ssa.IntULT, ssa.IntULE:
+			// if y is non-negative, so is any x which is unsigned less than it
+			if !y.IsNonNegative() {
+				return x
+			}
+			x = x.Intersect(ValueRange{Width: width, Lo: 0, Hi: maxSigned(width)})
+		default:
+			if !x.IsNonNegative() || !y.IsNonNegative() {
+				return x
+			}
+		}
+		pred = toSignedPredicate(pred)
+	}
+
+	switch pred {
+	case ssa.IntEQ:
+		return x.Intersect(y)
+	case ssa.IntNEQ:
+		if y.IsConstant() {
+			if x.Lo == y.Lo {
+				if x.IsConstant() {
+					return EmptyRange(width)
+				}
+				x.Lo++
+			} else if x.Hi == y.Lo {
+				x.Hi--
+			}
+		}
+		return x
+	case ssa.IntSLT:
+		if y.Hi == minSigned(width) {
+			return EmptyRange(width)
+		}
+		return x.Intersect(ValueRange{Width: width, Lo: minSigned(width), Hi: y.Hi - 1})
+	case ssa.IntSLE:
+		return x.Intersect(ValueRange{Width: width, Lo: minSigned(width), Hi: y.Hi})
+	case ssa.IntSGT:
+		if y.Lo == maxSigned(width) {
+			return EmptyRange(width)
+		}
+		return x.Intersect(ValueRange{Width: width, Lo: y.Lo + 1, Hi: maxSigned(width)})
+	case ssa.IntSGE:
+		return x.Intersect(ValueRange{Width: width, Lo: y.Lo, Hi: maxSigned(width)})
+	default:
+		panic("unim")
+	}
+}
+
+// RangeAnalysis computes the ranges of the integer values of a function.
+//
+// The range of a value where it is used is refined by the conditions of the CondBrs on the edges that
+// dominate the use. Phis merge the ranges of their incoming values on each edge, and phis in loop headers
+// are widened to the full range in the direction they grow in, so the analysis terminates quickly.
+// The result is also intersected with the range implied by the known bits of each value.
+type RangeAnalysis struct {
+	domTree   *DominatorTree
+	loopInfo  *LoopInfo
+	knownBits *KnownBitsAnalysis
+
+	ranges  map[ssa.Value]ValueRange // of instructions; empty until the instruction is reached
+	changes map[*ssa.Phi]int
+}
+
+// The number of times a phi which is not a loop header can change before it is widened.
+// Such phis can only be in cycles in irreducible control flow.
+const rangeWidenLimit = 8
+
+// NewRangeAnalysis computes the ranges of the integer values in the function the dominator tree was built from.
+// The dominator tree must not be a post-dominator tree.
+func NewRangeAnalysis(domTree *DominatorTree) *RangeAnalysis {
+	v := &RangeAnalysis{
+		domTree:   domTree,
+		loopInfo:  NewLoopInfo(domTree),
+		knownBits: NewKnownBitsAnalysis(),
+		ranges:    make(map[ssa.Value]ValueRange),
+		changes:   make(map[*ssa.Phi]int),
+	}
+	v.construct()
+	return v
+}
+
+// Returns the int width of the type, or 0 if it is not an int.
+func intWidth(typ types.Type) int {
+	if intType, ok := typ.(*types.Int); ok {
+		return intType.Width()
+	}
+	return 0
+}
+
+func (v *RangeAnalysis) construct() {
+	var instrs []ssa.Instruction
+	for _, node := range v.domTree.Nodes() {
+		if node.block == nil || !v.domTree.isReachable(node) {
+			continue
+		}
+
+		for _, instr := range node.block.Instrs() {
+			if val, ok := instr.(ssa.Value); ok && intWidth(val.Type()) != 0 {
+				v.ranges[val] = EmptyRange(intWidth(val.Type()))
+				instrs = append(instrs, instr)
+			}
+		}
+	}
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, instr := range instrs {
+			val := instr.(ssa.Value)
+			old := v.ranges[val]
+			res := old.Union(v.evaluate(instr))
+
+			if phi, ok := instr.(*ssa.Phi); ok && res != old && !old.IsEmpty() {
+				v.changes[phi]++
+				if v.loopInfo.IsLoopHeader(phi.Block()) || v.changes[phi] > rangeWidenLimit {
+					if res.Lo < old.Lo {
+						res.Lo = minSigned(res.Width)
+					}
+					if res.Hi > old.Hi {
+						res.Hi = maxSigned(res.Width)
+					}
+				}
+			}
+
+			if res != old {
+				v.ranges[val] = res
+				changed = true
+			}
+		}
+	}
+}
+
+func (v *RangeAnalysis) evaluate(instr ssa.Instruction) ValueRange {
+	val := instr.(ssa.Value)
+	width := intWidth(val.Type())
+	if width > 64 {
+		return FullRange(width)
+	}
+
+	block := instr.Block()
+	ops := ssa.GetOperands(instr)
+
+	var res ValueRange
+	switch instr := instr.(type) {
+	case *ssa.BinOp:
+		res = rangeBinOp(instr.BinOpType(), v.RangeAt(ops[0], block), v.RangeAt(ops[1], block))
+
+	case *ssa.ICmp:
+		res = rangeICmp(instr.Predicate(), v.RangeAt(ops[0], block), v.RangeAt(ops[1], block))
+
+	case *ssa.Convert:
+		res = v.rangeConvert(instr, width)
+
+	case *ssa.Phi:
+		res = EmptyRange(width)
+		for i := 0; i < instr.NumIncoming(); i++ {
+			incoming, pred := instr.GetIncoming(i)
+			if v.domTree.isReachable(v.domTree.NodeForBlock(pred)) {
+				res = res.Union(v.RangeOnEdge(incoming, pred, block))
+			}
+		}
+		return res
+
+	default:
+		// loads, calls and so on
+		return FullRange(width)
+	}
+
+	return res.Intersect(rangeFromKnownBits(v.knownBits.KnownBits(val)))
+}
+
+func (v *RangeAnalysis) rangeConvert(conv *ssa.Convert, width int) ValueRange {
+	src := ssa.GetOperands(conv)[0]
+
+	switch conv.ConvertType() {
+	case ssa.ConvertZExt:
+		x := v.RangeAt(src, conv.Block())
+		if x.IsEmpty() {
+			return EmptyRange(width)
+		}
+		if x.IsNonNegative() {
+			return ValueRange{Width: width, Lo: x.Lo, Hi: x.Hi}
+		}
+		if x.Hi < 0 {
+			// all the values have the sign bit set, so they become larger than any non-negative value
+			return ValueRange{Width: width, Lo: x.Lo + 1<<uint(x.Width), Hi: x.Hi + 1<<uint(x.Width)}
+		}
+		return ValueRange{Width: width, Lo: 0, Hi: int64(widthMask(x.Width))}
+
+	case ssa.ConvertSExt:
+		x := v.RangeAt(src, conv.Block())
+		if x.Width > 64 {
+			return FullRange(width)
+		}
+		return ValueRange{Width: width, Lo: x.Lo, Hi: x.Hi}
+
+	case ssa.ConvertTrunc:
+		x := v.RangeAt(src, conv.Block())
+		if x.IsEmpty() {
+			return EmptyRange(width)
+		}
+		if x.Lo >= minSigned(width) && x.Hi <= maxSigned(width) {
+			return ValueRange{Width: width, Lo: x.Lo, Hi: x.Hi}
+		}
+		return FullRange(width)
+
+	case ssa.ConvertPtrToInt, ssa.ConvertFToUI, ssa.ConvertFToSI:
+		return FullRange(width)
+
+	case ssa.ConvertBitcast, ssa.ConvertFExt, ssa.ConvertFTrunc, ssa.ConvertUIToF, ssa.ConvertSIToF, ssa.ConvertIntToPtr:
+		panic("internal error: convert does not produce an int")
+
+	default:
+		panic("unim")
+	}
+}
+
+// Range returns the range of an integer value wherever it is available. Panics if the value is not an int.
+func (v RangeAnalysis) Range(val ssa.Value) ValueRange {
+	width := intWidth(val.Type())
+	if width == 0 {
+		panic("Range: value is not an int")
+	} else if width > 64 {
+		return FullRange(width)
+	}
+
+	switch val := val.(type) {
+	case *ssa.IntLiteral:
+		return ConstantRange(width, val.LiteralValue().(uint64))
+	case ssa.Instruction:
+		if res, ok := v.ranges[val.(ssa.Value)]; ok {
+			return res
+		}
+		return EmptyRange(width) // unreachable
+	default:
+		return FullRange(width)
+	}
+}
+
+// RangeAt returns the range of an integer value where it is used in the block, refined by the conditions
+// of the branches which must be taken to reach the block.
+func (v RangeAnalysis) RangeAt(val ssa.Value, block *ssa.Block) ValueRange {
+	res := v.Range(val)
+	if _, ok := val.(*ssa.IntLiteral); ok || res.Width > 64 {
+		return res
+	}
+
+	// A block with a single predecessor is only reached along the edge from it, so the edge's condition holds
+	// in every block it dominates.
+	for node := v.domTree.NodeForBlock(block); node != nil && node.parent != nil; node = node.parent {
+		if preds := v.domTree.BlockCFG().NodeForBlock(node.block).Prev(); len(preds) == 1 {
+			res = v.refineOnEdge(val, res, preds[0].block, node.block)
+		}
+	}
+
+	return res
+}
+
+// RangeOnEdge returns the range of an integer value on the CFG edge between two blocks, such as where it
+// is used by a phi.
+func (v RangeAnalysis) RangeOnEdge(val ssa.Value, from, to *ssa.Block) ValueRange {
+	return v.refineOnEdge(val, v.RangeAt(val, from), from, to)
+}
+
+func (v RangeAnalysis) refineOnEdge(val ssa.Value, res ValueRange, from, to *ssa.Block) ValueRange {
+	condBr, ok := from.LastInstr().(*ssa.CondBr)
+	if !ok {
+		return res
+	}
+
+	ops := ssa.GetOperands(condBr)
+	trueTarget, falseTarget := ops[1].(*ssa.Block), ops[2].(*ssa.Block)
+	if trueTarget == falseTarget {
+		return res
+	}
+
+	icmp, ok := ops[0].(*ssa.ICmp)
+	if !ok {
+		return res
+	}
+
+	pred := icmp.Predicate()
+	if to == falseTarget {
+		pred = inversePredicate(pred)
+	}
+
+	cmpOps := ssa.GetOperands(icmp)
+	if cmpOps[0] == cmpOps[1] {
+		return res
+	}
+
+	// the unrefined range of the other operand is used, so refining one value doesn't need the refined range of another
+	if cmpOps[0] == val {
+		return refineByPredicate(pred, res, v.Range(cmpOps[1]))
+	} else if cmpOps[1] == val {
+		return refineByPredicate(SwappedPredicate(pred), res, v.Range(cmpOps[0]))
+	}
+	return res
+}
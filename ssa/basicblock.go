@@ -56,7 +56,7 @@ func (v Block) string(out *bufio.Writer) {
 	wpred := false
 	for _, ref := range refs {
 		switch ref.(type) {
-		case *Br, *CondBr:
+		case *Br, *CondBr, *Invoke:
 			if wpred {
 				out.WriteString(", ")
 			}
@@ -139,3 +139,177 @@ func (v Block) Instrs() []Instruction {
 func (v Block) Function() *Function {
 	return v.function
 }
+
+// Successors returns the blocks the terminating instruction of the block may branch to.
+// A block appears more than once if it is branched to more than once.
+func (v Block) Successors() []*Block {
+	switch term := v.LastInstr().(type) {
+	case *Br:
+		return []*Block{term.target.(*Block)}
+	case *CondBr:
+		return []*Block{term.trueTarget.(*Block), term.falseTarget.(*Block)}
+	case *Invoke:
+		return []*Block{term.NormalTarget(), term.UnwindTarget()}
+	default:
+		return nil
+	}
+}
+
+// Predecessors returns the blocks which branch to the block.
+// A block appears more than once if it branches to the block more than once.
+func (v *Block) Predecessors() []*Block {
+	var preds []*Block
+
+	for _, use := range v.uses {
+		switch use.user.(type) {
+		case *Br, *CondBr, *Invoke:
+			preds = append(preds, use.user.Block())
+		}
+	}
+
+	return preds
+}
+
+// EraseInstr removes the instruction from its block and drops the references it holds to its operands.
+// Panics if the instruction is a value which is still referenced.
+func EraseInstr(instr Instruction) {
+	if val, ok := instr.(Value); ok && len(val.References()) > 0 {
+		panic("EraseInstr: instruction is still referenced")
+	}
+
+	if instr.Block() == nil {
+		panic("EraseInstr: instruction is not in a block")
+	}
+
+	removeFromBlock(instr)
+	removeOperandUses(instr)
+	instr.setBlock(nil)
+}
+
+// Removes the instruction from the instructions of its block.
+func removeFromBlock(instr Instruction) {
+	b := instr.Block()
+	index := b.InstrIndex(instr)
+	copy(b.instrs[index:], b.instrs[index+1:])
+	b.instrs[len(b.instrs)-1] = nil
+	b.instrs = b.instrs[:len(b.instrs)-1]
+}
+
+// MoveInstrBefore moves the instruction to just before another instruction, which may be in a different block.
+// Its operands and uses are unchanged, so the caller must make sure it is still dominated by its operands
+// and dominates its uses.
+func MoveInstrBefore(instr, before Instruction) {
+	if instr.Block() == nil || before.Block() == nil {
+		panic("MoveInstrBefore: instruction is not in a block")
+	} else if instr == before {
+		return
+	}
+
+	removeFromBlock(instr)
+
+	b := before.Block()
+	index := b.InstrIndex(before)
+	b.instrs = append(b.instrs, nil)
+	copy(b.instrs[index+1:], b.instrs[index:])
+	b.instrs[index] = instr
+	instr.setBlock(b)
+}
+
+// MoveInstrToBlockEnd moves the instruction to the end of the block, like MoveInstrBefore.
+func MoveInstrToBlockEnd(instr Instruction, block *Block) {
+	if instr.Block() == nil {
+		panic("MoveInstrToBlockEnd: instruction is not in a block")
+	}
+
+	removeFromBlock(instr)
+	block.instrs = append(block.instrs, instr)
+	instr.setBlock(block)
+}
+
+// Returns true if every use is by one of the instructions, or by an instruction in one of the blocks.
+func usedOnlyBy(uses []*Use, instrs map[Instruction]bool, blocks map[*Block]bool) bool {
+	for _, use := range uses {
+		if !instrs[use.user] && !blocks[use.user.Block()] {
+			return false
+		}
+	}
+	return true
+}
+
+// EraseInstrs erases the instructions like EraseInstr, but they may refer to each other, such as phis in a cycle.
+// Panics if any of them is a value which is still referenced by another instruction.
+func EraseInstrs(instrs ...Instruction) {
+	set := make(map[Instruction]bool, len(instrs))
+	for _, instr := range instrs {
+		if instr.Block() == nil {
+			panic("EraseInstrs: instruction is not in a block")
+		}
+		set[instr] = true
+	}
+
+	for _, instr := range instrs {
+		if val, ok := instr.(Value); ok && !usedOnlyBy(val.Uses(), set, nil) {
+			panic("EraseInstrs: instruction is still referenced")
+		}
+	}
+
+	for _, instr := range instrs {
+		removeOperandUses(instr)
+	}
+	for _, instr := range instrs {
+		EraseInstr(instr)
+	}
+}
+
+// EraseBlocks removes the blocks from their functions along with their instructions, dropping the references the
+// instructions hold to their operands. The blocks and their instructions may refer to each other, but panics if
+// they are still referenced from any other block, so the phis of other blocks must not have them as incoming blocks.
+func EraseBlocks(blocks ...*Block) {
+	set := make(map[*Block]bool, len(blocks))
+	for _, block := range blocks {
+		if block.function == nil {
+			panic("EraseBlocks: block is not in a function")
+		}
+		set[block] = true
+	}
+
+	for _, block := range blocks {
+		if !usedOnlyBy(block.uses, nil, set) {
+			panic("EraseBlocks: block is still referenced")
+		}
+
+		for _, instr := range block.instrs {
+			if val, ok := instr.(Value); ok && !usedOnlyBy(val.Uses(), nil, set) {
+				panic("EraseBlocks: instruction is still referenced")
+			}
+		}
+	}
+
+	for _, block := range blocks {
+		for _, instr := range block.instrs {
+			removeOperandUses(instr)
+			instr.setBlock(nil)
+		}
+		block.instrs = nil
+	}
+
+	for _, block := range blocks {
+		fn := block.function
+		if fn == nil {
+			continue // already removed with another block of the function
+		}
+
+		remaining := fn.blocks[:0]
+		for _, b := range fn.blocks {
+			if set[b] {
+				b.function = nil
+			} else {
+				remaining = append(remaining, b)
+			}
+		}
+		for i := len(remaining); i < len(fn.blocks); i++ {
+			fn.blocks[i] = nil
+		}
+		fn.blocks = remaining
+	}
+}
@@ -37,6 +37,7 @@ const (
 
 type BinOp struct {
 	BlockHandler
+	OperandHandler
 	NameHandler
 	ReferenceHandler
 
@@ -61,7 +62,11 @@ func (v *BinOp) operands() []*Value {
 }
 
 func (v BinOp) String() string {
-	return strings.ToLower(v.binOpType.String()[5:]) + " " + ValueString(v.x) + ", " + ValueString(v.y)
+	return v.format(ValueIdentifier)
+}
+
+func (v BinOp) format(ident func(Value) string) string {
+	return strings.ToLower(v.binOpType.String()[5:]) + " " + valueString(ident, v.x) + ", " + valueString(ident, v.y)
 }
 
 func (v BinOp) Type() types.Type {
@@ -2,6 +2,7 @@ package ssa
 
 type Br struct {
 	BlockHandler
+	OperandHandler
 
 	target Value // must be a block
 }
@@ -13,7 +14,11 @@ func newBr(target *Block) *Br {
 }
 
 func (v Br) String() string {
-	return "br " + ValueString(v.target)
+	return v.format(ValueIdentifier)
+}
+
+func (v Br) format(ident func(Value) string) string {
+	return "br " + valueString(ident, v.target)
 }
 
 func (v *Br) operands() []*Value {
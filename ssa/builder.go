@@ -15,12 +15,44 @@ type Builder struct {
 	insertType  insertPointType
 	insertInstr Instruction
 	insertBlock *Block
+
+	checked bool
+	errs    []*BuildError
 }
 
 func NewBuilder() *Builder {
 	return &Builder{}
 }
 
+// NewCheckedBuilder returns a builder which checks the operand types of each instruction as it is created.
+// Rather than panicking or creating invalid IR silently, problems are recorded as BuildErrors
+// along with the Go stack of the caller, and can be retrieved with Errors or Err.
+// The instruction is still created and inserted, unless the builder has no insert point.
+func NewCheckedBuilder() *Builder {
+	return &Builder{checked: true}
+}
+
+// Errors returns the errors recorded by a checked builder, in the order they occurred.
+func (v Builder) Errors() []*BuildError {
+	return v.errs
+}
+
+// Err returns the first error recorded by a checked builder, or nil if there is none.
+func (v Builder) Err() error {
+	if len(v.errs) == 0 {
+		return nil
+	}
+	return v.errs[0]
+}
+
+func (v *Builder) recordError(i Instruction, message string) {
+	v.errs = append(v.errs, &BuildError{
+		Message: message,
+		Instr:   i,
+		Stack:   callerStack(),
+	})
+}
+
 func (v *Builder) insert(i Instruction) {
 	switch v.insertType {
 	case insertAfterInstr:
@@ -86,17 +118,23 @@ func (v *Builder) currentBlock() *Block {
 
 func (v *Builder) setupInstr(i Instruction, name string) {
 	if v.insertType == insertUndefined {
+		if v.checked {
+			v.recordError(i, "Uninitialised builder")
+			return
+		}
 		panic("uninitialised builder")
 	}
 
+	if v.checked {
+		if message := checkInstr(i, v.currentBlock()); message != "" {
+			v.recordError(i, message)
+		}
+	}
+
 	i.setBlock(v.currentBlock())
 	v.insert(i)
 
-	for _, op := range i.operands() {
-		if *op != nil {
-			(*op).addReference(i)
-		}
-	}
+	addOperandUses(i)
 
 	if name != "" {
 		i.(Value).SetName(name)
@@ -164,7 +202,14 @@ func (v *Builder) CreateStore(location, value Value) *Store {
 }
 
 func (v *Builder) CreateAlloc(typ types.Type, name string) *Alloc {
-	i := newAlloc(typ)
+	i := newAlloc(typ, nil)
+	v.setupInstr(i, name)
+	return i
+}
+
+// CreateArrayAlloc creates an Alloc of count elements. count must be an int, and need not be a literal.
+func (v *Builder) CreateArrayAlloc(typ types.Type, count Value, name string) *Alloc {
+	i := newAlloc(typ, count)
 	v.setupInstr(i, name)
 	return i
 }
@@ -180,3 +225,21 @@ func (v *Builder) CreatePhi(t types.Type, name string) *Phi {
 	v.setupInstr(i, name)
 	return i
 }
+
+func (v *Builder) CreateInvoke(fn *Function, args []Value, normalTarget, unwindTarget *Block, name string) *Invoke {
+	i := newInvoke(fn, args, normalTarget, unwindTarget)
+	v.setupInstr(i, name)
+	return i
+}
+
+func (v *Builder) CreateLandingPad(cleanup bool, name string) *LandingPad {
+	i := newLandingPad(cleanup)
+	v.setupInstr(i, name)
+	return i
+}
+
+func (v *Builder) CreateExtractValue(value Value, index int, name string) *ExtractValue {
+	i := newExtractValue(value, index)
+	v.setupInstr(i, name)
+	return i
+}
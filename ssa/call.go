@@ -6,6 +6,7 @@ type Call struct {
 	NameHandler
 	ReferenceHandler
 	BlockHandler
+	OperandHandler
 
 	function  Value
 	arguments []Value
@@ -33,7 +34,11 @@ func (v *Call) operands() []*Value {
 }
 
 func (v Call) String() string {
-	return "call " + v.Type().String() + " @" + v.function.Name() + "(" + valueListString(v.arguments) + ")"
+	return v.format(ValueIdentifier)
+}
+
+func (v Call) format(ident func(Value) string) string {
+	return "call " + v.Type().String() + " " + ident(v.function) + "(" + valueListString(ident, v.arguments) + ")"
 }
 
 func (_ Call) IsTerminating() bool {
@@ -0,0 +1,247 @@
+package ssa
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// BuildError is an error found by a checked Builder while creating an instruction.
+type BuildError struct {
+	Message string
+	Instr   Instruction
+	Stack   string // the Go stack of the caller which created the instruction
+}
+
+func (v BuildError) Error() string {
+	instrStr := fmt.Sprintf("%T", v.Instr) // the instruction can't be printed if it has nil operands
+
+	if hasNilOperand(v.Instr) {
+		// use the type name
+	} else if v.Instr.Block() != nil {
+		instrStr = InstrTrace(v.Instr)
+	} else {
+		instrStr = "`" + v.Instr.String() + "`"
+	}
+
+	return fmt.Sprintf("BuildError: %s\n -> %s\n%s", v.Message, instrStr, v.Stack)
+}
+
+// Returns true if the instruction has a nil operand where one is not allowed.
+func hasNilOperand(instr Instruction) bool {
+	switch instr.(type) {
+	case *Ret, *Alloc:
+		return false
+	}
+
+	for _, op := range GetOperands(instr) {
+		if op == nil {
+			return true
+		}
+	}
+	return false
+}
+
+var ssaPackagePrefix = func() string {
+	pc, _, _, _ := runtime.Caller(0)
+	name := runtime.FuncForPC(pc).Name()
+	return name[:strings.LastIndex(name, "/")] + "/ssa."
+}()
+
+// callerStack returns the Go stack, omitting the frames inside this package.
+func callerStack() string {
+	pcs := make([]uintptr, 32)
+	pcs = pcs[:runtime.Callers(1, pcs)]
+
+	buf := ""
+	inPackage := true
+	frames := runtime.CallersFrames(pcs)
+	for {
+		frame, more := frames.Next()
+
+		if inPackage && !strings.HasPrefix(frame.Function, ssaPackagePrefix) {
+			inPackage = false
+		}
+
+		if !inPackage {
+			buf += fmt.Sprintf("%s\n\t%s:%d\n", frame.Function, frame.File, frame.Line)
+		}
+
+		if !more {
+			break
+		}
+	}
+
+	return buf
+}
+
+// checkInstr checks the operand types of an instruction about to be inserted into the specified block.
+// Returns an empty string if no problem was found.
+func checkInstr(instr Instruction, block *Block) string {
+	ops := GetOperands(instr)
+
+	if hasNilOperand(instr) {
+		return "Nil operand"
+	}
+
+	switch i := instr.(type) {
+	case *BinOp:
+		return checkBinOp(i.BinOpType(), ops[0].Type(), ops[1].Type())
+
+	case *ICmp:
+		if !ops[0].Type().Equals(ops[1].Type()) {
+			return mismatchedTypes(ops[0].Type(), ops[1].Type())
+		} else if _, ok := ops[0].Type().(*types.Int); !ok {
+			return "Expected int type, found `" + ops[0].Type().String() + "`"
+		}
+
+	case *Store:
+		ptr, ok := ops[0].Type().(*types.Pointer)
+		if !ok {
+			return "Expected pointer type, found `" + ops[0].Type().String() + "`"
+		} else if !ptr.Element().Equals(ops[1].Type()) {
+			return mismatchedTypes(ptr.Element(), ops[1].Type())
+		}
+
+	case *Alloc:
+		if ops[0] != nil {
+			if _, ok := ops[0].Type().(*types.Int); !ok {
+				return "Expected int type for element count, found `" + ops[0].Type().String() + "`"
+			}
+		}
+
+	case *Load:
+		if _, ok := ops[0].Type().(*types.Pointer); !ok {
+			return "Expected pointer type, found `" + ops[0].Type().String() + "`"
+		}
+
+	case *Call:
+		return checkCall(ops[0], ops[1:])
+
+	case *Invoke:
+		return checkCall(ops[0], ops[1:len(ops)-2])
+
+	case *GEP:
+		return checkGEP(ops[0], ops[1:])
+
+	case *ExtractValue:
+		switch typ := ops[0].Type().(type) {
+		case *types.Struct:
+			if i.Index() < 0 || i.Index() >= len(typ.Fields()) {
+				return fmt.Sprintf("Index %d out of range", i.Index())
+			}
+		case *types.Array:
+			if i.Index() < 0 || i.Index() >= typ.Length() {
+				return fmt.Sprintf("Index %d out of range", i.Index())
+			}
+		default:
+			return "Expected struct or array type, found `" + typ.String() + "`"
+		}
+
+	case *CondBr:
+		if !ops[0].Type().Equals(types.NewInt(1)) {
+			return "Expected type i1, found `" + ops[0].Type().String() + "`"
+		}
+
+	case *Ret:
+		retType := block.Function().Type().(*types.Signature).ReturnType()
+		if ops[0] == nil {
+			if _, ok := retType.(types.Void); !ok {
+				return "Expected return value of type `" + retType.String() + "`"
+			}
+		} else if !ops[0].Type().Equals(retType) {
+			return mismatchedTypes(ops[0].Type(), retType)
+		}
+	}
+
+	return ""
+}
+
+func mismatchedTypes(t1, t2 types.Type) string {
+	return fmt.Sprintf("Mismatched types `%s` and `%s`", t1, t2)
+}
+
+func checkBinOp(binOpType BinOpType, x, y types.Type) string {
+	if !x.Equals(y) {
+		return mismatchedTypes(x, y)
+	}
+
+	switch binOpType {
+	case BinOpFAdd, BinOpFSub, BinOpFMul, BinOpFDiv, BinOpFRem:
+		if _, ok := x.(*types.Float); !ok {
+			return "`" + binOpType.String() + "` requires float"
+		}
+
+	default:
+		if _, ok := x.(*types.Int); !ok {
+			return "`" + binOpType.String() + "` requires int"
+		}
+	}
+
+	return ""
+}
+
+func checkCall(fn Value, args []Value) string {
+	sig, ok := fn.Type().(*types.Signature)
+	if !ok {
+		return "Expected function type, found `" + fn.Type().String() + "`"
+	}
+
+	if len(args) > len(sig.Parameters()) && !sig.Variadic() {
+		return "Too many arguments to function `" + ValueIdentifier(fn) + "`"
+	} else if len(args) < len(sig.Parameters()) {
+		return "Too few arguments to function `" + ValueIdentifier(fn) + "`"
+	}
+
+	for i, arg := range args {
+		if !types.IsFirstClass(arg.Type()) {
+			return fmt.Sprintf("Argument %d has non-first class type `%s`", i, arg.Type())
+		}
+
+		if i < len(sig.Parameters()) && !arg.Type().Equals(sig.Parameters()[i]) {
+			return fmt.Sprintf("Argument %d: %s", i, mismatchedTypes(arg.Type(), sig.Parameters()[i]))
+		}
+	}
+
+	return ""
+}
+
+func checkGEP(value Value, indexes []Value) string {
+	typ := value.Type()
+	if _, ok := typ.(*types.Pointer); !ok {
+		return "Expected pointer type, found `" + typ.String() + "`"
+	}
+
+	for i, index := range indexes {
+		if _, ok := index.Type().(*types.Int); !ok {
+			return fmt.Sprintf("Index %d is not an int", i)
+		}
+
+		switch styp := typ.(type) {
+		case *types.Pointer:
+			if i != 0 {
+				return fmt.Sprintf("Index %d dereferences a pointer (only the index 0 may dereference a pointer)", i)
+			}
+			typ = styp.Element()
+
+		case *types.Array:
+			typ = styp.Element()
+
+		case *types.Struct:
+			lit, ok := index.(*IntLiteral)
+			if !ok {
+				return fmt.Sprintf("Expected int literal at index %d", i)
+			} else if lit.value >= uint64(len(styp.Fields())) {
+				return fmt.Sprintf("Index %d has value greater than number of struct fields", i)
+			}
+			typ = styp.Fields()[lit.value]
+
+		default:
+			return fmt.Sprintf("Index %d is invalid", i)
+		}
+	}
+
+	return ""
+}
@@ -2,6 +2,7 @@ package ssa
 
 type CondBr struct {
 	BlockHandler
+	OperandHandler
 
 	condition               Value // must be i1
 	trueTarget, falseTarget Value // must be blocks
@@ -16,7 +17,11 @@ func newCondBr(condition Value, trueTarget, falseTarget *Block) *CondBr {
 }
 
 func (v CondBr) String() string {
-	return "condbr " + ValueString(v.condition) + ", " + ValueString(v.trueTarget) + ", " + ValueString(v.falseTarget)
+	return v.format(ValueIdentifier)
+}
+
+func (v CondBr) format(ident func(Value) string) string {
+	return "condbr " + valueString(ident, v.condition) + ", " + valueString(ident, v.trueTarget) + ", " + valueString(ident, v.falseTarget)
 }
 
 func (v *CondBr) operands() []*Value {
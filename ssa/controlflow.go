@@ -0,0 +1,276 @@
+package ssa
+
+import "github.com/MovingtoMars/nnvm/types"
+
+// The structured control flow helpers create and link the blocks of common constructs.
+// They must be called with the insert point at the end of a block, and always leave the insert point
+// at the end of the join block of the construct. Callbacks are called with the insert point at the end
+// of the block they should fill. If a callback terminates the current block itself (for example with a Ret,
+// or with Loop.Break), no branch to the join block is added for it.
+
+func (v *Builder) requireBlockEnd() *Block {
+	if v.insertType != insertBlockEnd {
+		panic("structured control flow requires the insert point to be at the end of a block")
+	}
+
+	if last := v.insertBlock.LastInstr(); last != nil && last.IsTerminating() {
+		panic("structured control flow requires the current block to be unterminated")
+	}
+
+	return v.insertBlock
+}
+
+// Returns the current block, or nil if the current block has already been terminated.
+func (v *Builder) openBlock() *Block {
+	b := v.currentBlock()
+	if last := b.LastInstr(); last != nil && last.IsTerminating() {
+		return nil
+	}
+	return b
+}
+
+// If holds the blocks created by CreateIf.
+type If struct {
+	Then *Block
+	Else *Block // nil if there is no else branch
+	Join *Block
+
+	// The blocks which branch from each branch to the join block.
+	// nil if the branch does not reach the join block.
+	ThenExit, ElseExit *Block
+}
+
+// CreateIf creates an if statement, with an optional else branch if els is not nil.
+// The join block is always created. If neither branch reaches it, it has no predecessors and must still be
+// terminated by the caller, for example with CreateUnreachable.
+func (v *Builder) CreateIf(cond Value, then, els func()) *If {
+	start := v.requireBlockEnd()
+	fn := start.Function()
+
+	res := &If{Then: fn.AddBlockAtEnd("if.then")}
+	if els != nil {
+		res.Else = fn.AddBlockAtEnd("if.else")
+	}
+	res.Join = fn.AddBlockAtEnd("if.join")
+
+	if res.Else != nil {
+		v.CreateCondBr(cond, res.Then, res.Else)
+	} else {
+		v.CreateCondBr(cond, res.Then, res.Join)
+	}
+
+	res.ThenExit = v.fillBlock(res.Then, then, res.Join)
+	if res.Else != nil {
+		res.ElseExit = v.fillBlock(res.Else, els, res.Join)
+	} else {
+		res.ElseExit = start
+	}
+
+	v.SetInsertAtBlockEnd(res.Join)
+	return res
+}
+
+// CreateIfValue creates an if/else expression, returning a phi in the join block which merges the
+// values returned by the two branches. Both branches must reach the join block.
+func (v *Builder) CreateIfValue(cond Value, then, els func() Value, name string) *Phi {
+	var thenVal, elseVal Value
+
+	res := v.CreateIf(cond, func() {
+		thenVal = then()
+	}, func() {
+		elseVal = els()
+	})
+
+	if res.ThenExit == nil || res.ElseExit == nil {
+		panic("CreateIfValue: both branches must reach the join block")
+	}
+
+	phi := v.CreatePhi(thenVal.Type(), name)
+	phi.AddIncoming(thenVal, res.ThenExit)
+	phi.AddIncoming(elseVal, res.ElseExit)
+	return phi
+}
+
+// Calls fill with the insert point at the end of the block, then branches to join if the
+// block fill finished in is unterminated, returning that block. Returns nil if it was terminated.
+func (v *Builder) fillBlock(block *Block, fill func(), join *Block) *Block {
+	v.SetInsertAtBlockEnd(block)
+	if fill != nil {
+		fill()
+	}
+
+	exit := v.openBlock()
+	if exit != nil {
+		v.CreateBr(join)
+	}
+	return exit
+}
+
+// CreateLogicalAnd creates a short-circuiting boolean and. y is only evaluated if x is true.
+// Returns an i1 phi in the join block. If y terminates the block it finishes in, its value is ignored.
+func (v *Builder) CreateLogicalAnd(x Value, y func() Value, name string) *Phi {
+	return v.createShortCircuit(x, y, false, name)
+}
+
+// CreateLogicalOr creates a short-circuiting boolean or. y is only evaluated if x is false.
+// Returns an i1 phi in the join block. If y terminates the block it finishes in, its value is ignored.
+func (v *Builder) CreateLogicalOr(x Value, y func() Value, name string) *Phi {
+	return v.createShortCircuit(x, y, true, name)
+}
+
+func (v *Builder) createShortCircuit(x Value, y func() Value, isOr bool, name string) *Phi {
+	start := v.requireBlockEnd()
+	fn := start.Function()
+
+	rhs := fn.AddBlockAtEnd("sc.rhs")
+	join := fn.AddBlockAtEnd("sc.join")
+
+	shortValue := uint64(0)
+	if isOr {
+		shortValue = 1
+		v.CreateCondBr(x, join, rhs)
+	} else {
+		v.CreateCondBr(x, rhs, join)
+	}
+
+	v.SetInsertAtBlockEnd(rhs)
+	yVal := y()
+	rhsExit := v.openBlock()
+	if rhsExit != nil {
+		v.CreateBr(join)
+	}
+
+	v.SetInsertAtBlockEnd(join)
+	phi := v.CreatePhi(types.NewInt(1), name)
+	phi.AddIncoming(NewIntLiteral(shortValue, types.NewInt(1)), start)
+	if rhsExit != nil {
+		phi.AddIncoming(yVal, rhsExit)
+	}
+	return phi
+}
+
+// Loop holds the blocks and loop-carried values of a loop created by CreateWhile or CreateFor.
+type Loop struct {
+	Header *Block // evaluates the condition, and holds a phi for each loop-carried value
+	Body   *Block // the first block of the body
+	Latch  *Block // the target of Continue; runs the step of a for loop, equal to Header for while loops
+	Exit   *Block // the join block after the loop
+
+	builder   *Builder
+	vars      []*Phi
+	latchVars []*Phi // nil for while loops
+	continued bool   // whether anything branches to the latch
+}
+
+// Vars returns the loop-carried values at the start of the current iteration, in the order their initial
+// values were passed. They can be used anywhere in the body, and in the exit block after the loop.
+func (v Loop) Vars() []*Phi {
+	return v.vars
+}
+
+// Continue branches to the next iteration of the loop. next holds the next value of each loop-carried value.
+func (v *Loop) Continue(next []Value) {
+	if len(next) != len(v.vars) {
+		panic("Loop.Continue: wrong number of loop-carried values")
+	}
+
+	from := v.builder.currentBlock()
+	v.builder.CreateBr(v.Latch)
+	v.continued = true
+
+	phis := v.vars
+	if v.latchVars != nil {
+		phis = v.latchVars
+	}
+	for i, phi := range phis {
+		phi.AddIncoming(next[i], from)
+	}
+}
+
+// Break branches to the exit block of the loop. The loop-carried values in the exit block are their
+// values at the start of the iteration which broke out of the loop.
+func (v *Loop) Break() {
+	v.builder.CreateBr(v.Exit)
+}
+
+// CreateWhile creates a while loop, which evaluates cond before every iteration.
+// init holds the initial values of the loop-carried values, and body returns their next values if
+// it finishes in an unterminated block.
+func (v *Builder) CreateWhile(init []Value, cond func(vars []*Phi) Value, body func(l *Loop) []Value) *Loop {
+	return v.createLoop(init, cond, body, nil)
+}
+
+// CreateFor creates a for loop. It is like CreateWhile, except that Continue and the end of the body
+// branch to a latch block, where step is called with the merged loop-carried values to compute their
+// values for the next iteration. If the body never continues, step is not called and the latch is
+// terminated with an Unreachable.
+func (v *Builder) CreateFor(init []Value, cond func(vars []*Phi) Value, step func(vars []*Phi) []Value, body func(l *Loop) []Value) *Loop {
+	if step == nil {
+		panic("CreateFor: step is nil")
+	}
+
+	return v.createLoop(init, cond, body, step)
+}
+
+func (v *Builder) createLoop(init []Value, cond func(vars []*Phi) Value, body func(l *Loop) []Value, step func(vars []*Phi) []Value) *Loop {
+	start := v.requireBlockEnd()
+	fn := start.Function()
+
+	l := &Loop{builder: v}
+	l.Header = fn.AddBlockAtEnd("loop.header")
+	l.Body = fn.AddBlockAtEnd("loop.body")
+	l.Latch = l.Header
+	if step != nil {
+		l.Latch = fn.AddBlockAtEnd("loop.latch")
+	}
+	l.Exit = fn.AddBlockAtEnd("loop.exit")
+
+	v.CreateBr(l.Header)
+
+	v.SetInsertAtBlockEnd(l.Header)
+	for _, val := range init {
+		phi := v.CreatePhi(val.Type(), "")
+		phi.AddIncoming(val, start)
+		l.vars = append(l.vars, phi)
+	}
+	v.CreateCondBr(cond(l.vars), l.Body, l.Exit)
+
+	if step != nil {
+		v.SetInsertAtBlockEnd(l.Latch)
+		for _, val := range init {
+			l.latchVars = append(l.latchVars, v.CreatePhi(val.Type(), ""))
+		}
+	}
+
+	v.SetInsertAtBlockEnd(l.Body)
+	next := body(l)
+	if v.openBlock() != nil {
+		l.Continue(next)
+	}
+
+	if step != nil && !l.continued {
+		// the latch has no predecessors, so its phis would have no incoming values
+		for _, phi := range l.latchVars {
+			EraseInstr(phi)
+		}
+		l.latchVars = nil
+
+		v.SetInsertAtBlockEnd(l.Latch)
+		v.CreateUnreachable()
+	} else if step != nil {
+		v.SetInsertAtBlockEnd(l.Latch)
+		next := step(l.latchVars)
+		if len(next) != len(l.vars) {
+			panic("CreateFor: step returned wrong number of loop-carried values")
+		}
+
+		from := v.currentBlock()
+		v.CreateBr(l.Header)
+		for i, phi := range l.vars {
+			phi.AddIncoming(next[i], from)
+		}
+	}
+
+	v.SetInsertAtBlockEnd(l.Exit)
+	return l
+}
@@ -36,6 +36,7 @@ const (
 type Convert struct {
 	NameHandler
 	BlockHandler
+	OperandHandler
 	ReferenceHandler
 
 	value       Value
@@ -56,7 +57,11 @@ func (v Convert) ConvertType() ConvertType {
 }
 
 func (v Convert) String() string {
-	return strings.ToLower(v.convertType.String()[7:]) + " " + ValueString(v.value) + " to " + v.target.String()
+	return v.format(ValueIdentifier)
+}
+
+func (v Convert) format(ident func(Value) string) string {
+	return strings.ToLower(v.convertType.String()[7:]) + " " + valueString(ident, v.value) + " to " + v.target.String()
 }
 
 func (v Convert) Type() types.Type {
@@ -0,0 +1,59 @@
+package ssa
+
+import (
+	"fmt"
+
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// ExtractValue extracts a field of a struct value, or an element of an array value, at a constant index.
+type ExtractValue struct {
+	NameHandler
+	ReferenceHandler
+	BlockHandler
+	OperandHandler
+
+	value Value
+	index int
+}
+
+func newExtractValue(value Value, index int) *ExtractValue {
+	return &ExtractValue{
+		value: value,
+		index: index,
+	}
+}
+
+func (v ExtractValue) Index() int {
+	return v.index
+}
+
+func (v *ExtractValue) operands() []*Value {
+	return []*Value{&v.value}
+}
+
+func (v ExtractValue) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (v ExtractValue) format(ident func(Value) string) string {
+	return "extractvalue " + valueString(ident, v.value) + ", " + fmt.Sprintf("%d", v.index)
+}
+
+func (v ExtractValue) Type() types.Type {
+	switch typ := v.value.Type().(type) {
+	case *types.Struct:
+		if v.index >= 0 && v.index < len(typ.Fields()) {
+			return typ.Fields()[v.index]
+		}
+
+	case *types.Array:
+		return typ.Element()
+	}
+
+	return types.NewVoid()
+}
+
+func (_ ExtractValue) IsTerminating() bool {
+	return false
+}
@@ -16,6 +16,31 @@ type Function struct {
 	parameters []*Parameter
 
 	blocks []*Block
+
+	personality *Function // nil if the function has no personality
+	inlineHint  InlineHint
+}
+
+// InlineHint tells inlining passes whether calls to a function should be inlined.
+type InlineHint int
+
+const (
+	InlineDefault InlineHint = iota // left to the inliner's cost model
+	InlineAlways                    // always inlined where possible
+	InlineNever                     // never inlined
+)
+
+func (v InlineHint) String() string {
+	switch v {
+	case InlineDefault:
+		return "InlineDefault"
+	case InlineAlways:
+		return "InlineAlways"
+	case InlineNever:
+		return "InlineNever"
+	default:
+		panic("unim")
+	}
 }
 
 func newFunction(typ *types.Signature, name string) *Function {
@@ -52,6 +77,25 @@ func (v Function) EntryBlock() *Block {
 	return nil
 }
 
+// Personality returns the personality function used to unwind exceptions through the function, or nil.
+func (v Function) Personality() *Function {
+	return v.personality
+}
+
+// SetPersonality sets the personality function, which is required if the function contains landing pads.
+// For the Itanium C++ ABI, this is usually `__gxx_personality_v0`.
+func (v *Function) SetPersonality(personality *Function) {
+	v.personality = personality
+}
+
+func (v Function) InlineHint() InlineHint {
+	return v.inlineHint
+}
+
+func (v *Function) SetInlineHint(hint InlineHint) {
+	v.inlineHint = hint
+}
+
 func (v Function) Type() types.Type {
 	return v.typ
 }
@@ -117,6 +161,17 @@ func (v Function) SignatureString() string {
 func (v Function) string(out *bufio.Writer) {
 	out.WriteString(v.SignatureString())
 
+	if v.personality != nil {
+		out.WriteString(" personality @" + v.personality.Name())
+	}
+
+	switch v.inlineHint {
+	case InlineAlways:
+		out.WriteString(" alwaysinline")
+	case InlineNever:
+		out.WriteString(" noinline")
+	}
+
 	if len(v.blocks) > 0 {
 		out.WriteString(" {\n")
 		for i, block := range v.blocks {
@@ -146,3 +201,35 @@ func (v *Function) AddBlockAtEnd(name string) *Block {
 	v.blocks = append(v.blocks, b)
 	return b
 }
+
+// AddBlockBefore adds a block to the function just before another block in it.
+func (v *Function) AddBlockBefore(name string, before *Block) *Block {
+	b := newBlock(name)
+	b.function = v
+
+	index := 0
+	for index < len(v.blocks) && v.blocks[index] != before {
+		index++
+	}
+	if index == len(v.blocks) {
+		panic("AddBlockBefore: block is not in the function")
+	}
+
+	v.blocks = append(v.blocks, nil)
+	copy(v.blocks[index+1:], v.blocks[index:])
+	v.blocks[index] = b
+	return b
+}
+
+// AddBlockAfter adds a block to the function just after another block in it.
+func (v *Function) AddBlockAfter(name string, after *Block) *Block {
+	for i, b := range v.blocks {
+		if b == after {
+			if i == len(v.blocks)-1 {
+				return v.AddBlockAtEnd(name)
+			}
+			return v.AddBlockBefore(name, v.blocks[i+1])
+		}
+	}
+	panic("AddBlockAfter: block is not in the function")
+}
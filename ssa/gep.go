@@ -7,6 +7,7 @@ type GEP struct {
 	NameHandler
 	ReferenceHandler
 	BlockHandler
+	OperandHandler
 
 	value   Value
 	indexes []Value
@@ -20,7 +21,11 @@ func newGEP(value Value, indexes []Value) *GEP {
 }
 
 func (v GEP) String() string {
-	return "gep " + ValueString(v.value) + ", " + valueListString(v.indexes)
+	return v.format(ValueIdentifier)
+}
+
+func (v GEP) format(ident func(Value) string) string {
+	return "gep " + valueString(ident, v.value) + ", " + valueListString(ident, v.indexes)
 }
 
 func (v *GEP) operands() []*Value {
@@ -1,37 +1,45 @@
 package ssa
 
 type ReferenceHandler struct {
-	references []Instruction
+	uses []*Use
 }
 
-func (v ReferenceHandler) References() []Instruction {
-	return v.references
+func (v ReferenceHandler) Uses() []*Use {
+	return v.uses
 }
 
-func (v *ReferenceHandler) addReference(ref Instruction) {
-	v.references = append(v.references, ref)
+func (v ReferenceHandler) References() []Instruction {
+	refs := make([]Instruction, len(v.uses))
+	for i, use := range v.uses {
+		refs[i] = use.user
+	}
+	return refs
 }
 
-func (v *ReferenceHandler) removeReference(ref Instruction) {
-	loc := -1
+func (v *ReferenceHandler) addUse(use *Use) {
+	use.listIndex = len(v.uses)
+	v.uses = append(v.uses, use)
+}
 
-	for i, eref := range v.references {
-		if eref == ref {
-			loc = i
-			break
-		}
+func (v *ReferenceHandler) removeUse(use *Use) {
+	if use.listIndex >= len(v.uses) || v.uses[use.listIndex] != use {
+		panic("tried to remove non-existant use")
 	}
 
-	if loc == -1 {
-		panic("tried to remove non-existant reference")
-	}
+	last := v.uses[len(v.uses)-1]
+	last.listIndex = use.listIndex
+	v.uses[use.listIndex] = last
+	v.uses[len(v.uses)-1] = nil
+	v.uses = v.uses[:len(v.uses)-1]
+}
 
-	copy(v.references[loc:], v.references[loc+1:])
-	v.references = v.references[:len(v.references)-1]
+// OperandHandler holds the uses of the operands of an instruction.
+type OperandHandler struct {
+	operandUses []*Use // nil for nil operands
 }
 
-func (v *ReferenceHandler) clearReferences() {
-	v.references = nil
+func (v *OperandHandler) useList() *[]*Use {
+	return &v.operandUses
 }
 
 type NameHandler struct {
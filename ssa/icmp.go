@@ -25,6 +25,7 @@ const (
 
 type ICmp struct {
 	BlockHandler
+	OperandHandler
 	NameHandler
 	ReferenceHandler
 
@@ -49,7 +50,11 @@ func (v *ICmp) operands() []*Value {
 }
 
 func (v ICmp) String() string {
-	return "icmp " + strings.ToLower(v.predicate.String()[3:]) + " " + ValueString(v.x) + ", " + ValueString(v.y)
+	return v.format(ValueIdentifier)
+}
+
+func (v ICmp) format(ident func(Value) string) string {
+	return "icmp " + strings.ToLower(v.predicate.String()[3:]) + " " + valueString(ident, v.x) + ", " + valueString(ident, v.y)
 }
 
 func (_ ICmp) Type() types.Type {
@@ -0,0 +1,62 @@
+package ssa
+
+import "github.com/MovingtoMars/nnvm/types"
+
+// Invoke is a call which terminates its block.
+// If the callee returns normally, control continues at the normal target. If an exception unwinds through
+// the call, control continues at the unwind target, which must begin with a LandingPad.
+// The value of an Invoke is only available in blocks dominated by the normal target.
+type Invoke struct {
+	NameHandler
+	ReferenceHandler
+	BlockHandler
+	OperandHandler
+
+	function                   Value
+	arguments                  []Value
+	normalTarget, unwindTarget Value // must be blocks
+}
+
+func newInvoke(target *Function, args []Value, normalTarget, unwindTarget *Block) *Invoke {
+	return &Invoke{
+		function:     target,
+		arguments:    args,
+		normalTarget: normalTarget,
+		unwindTarget: unwindTarget,
+	}
+}
+
+func (v Invoke) Type() types.Type {
+	return v.function.(*Function).typ.ReturnType()
+}
+
+func (v Invoke) NormalTarget() *Block {
+	return v.normalTarget.(*Block)
+}
+
+func (v Invoke) UnwindTarget() *Block {
+	return v.unwindTarget.(*Block)
+}
+
+func (v *Invoke) operands() []*Value {
+	ops := []*Value{&v.function}
+
+	for i := 0; i < len(v.arguments); i++ {
+		ops = append(ops, &v.arguments[i])
+	}
+
+	return append(ops, &v.normalTarget, &v.unwindTarget)
+}
+
+func (v Invoke) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (v Invoke) format(ident func(Value) string) string {
+	return "invoke " + v.Type().String() + " " + ident(v.function) + "(" + valueListString(ident, v.arguments) + ") to " +
+		valueString(ident, v.normalTarget) + " unwind " + valueString(ident, v.unwindTarget)
+}
+
+func (_ Invoke) IsTerminating() bool {
+	return true
+}
@@ -0,0 +1,100 @@
+package ssa
+
+import "github.com/MovingtoMars/nnvm/types"
+
+// LandingPad receives an exception at the start of the unwind target of an Invoke.
+// It must be the first non-phi instruction in its block, and the function containing it must have a personality.
+//
+// Its value is a { *i8, i32 } struct. The first field is the exception object. The second field is the
+// selector: the index of the matching catch clause plus one, with the catch-all clause coming after every
+// other clause, or 0 if the landing pad was entered only to run cleanup code.
+type LandingPad struct {
+	NameHandler
+	ReferenceHandler
+	BlockHandler
+	OperandHandler
+
+	cleanup  bool
+	catchAll bool
+	catches  []Value // type info values, usually globals
+}
+
+func newLandingPad(cleanup bool) *LandingPad {
+	return &LandingPad{
+		cleanup: cleanup,
+	}
+}
+
+// LandingPadType returns the type of the value of a LandingPad.
+func LandingPadType() *types.Struct {
+	return types.NewStruct([]types.Type{types.NewPointer(types.NewInt(8)), types.NewInt(32)}, false)
+}
+
+func (_ LandingPad) Type() types.Type {
+	return LandingPadType()
+}
+
+func (v LandingPad) IsCleanup() bool {
+	return v.cleanup
+}
+
+func (v *LandingPad) SetCleanup(cleanup bool) {
+	v.cleanup = cleanup
+}
+
+// AddCatch adds a clause which catches exceptions matching the type info.
+func (v *LandingPad) AddCatch(typeInfo Value) {
+	v.catches = append(v.catches, typeInfo)
+	v.operandUses = append(v.operandUses, newUse(v, len(v.catches)-1, typeInfo))
+}
+
+func (v LandingPad) NumCatches() int {
+	return len(v.catches)
+}
+
+func (v LandingPad) Catch(index int) Value {
+	return v.catches[index]
+}
+
+// SetCatchAll sets whether the landing pad has a clause which catches every exception.
+func (v *LandingPad) SetCatchAll(catchAll bool) {
+	v.catchAll = catchAll
+}
+
+func (v LandingPad) IsCatchAll() bool {
+	return v.catchAll
+}
+
+func (v *LandingPad) operands() []*Value {
+	var ops []*Value
+	for i := range v.catches {
+		ops = append(ops, &v.catches[i])
+	}
+	return ops
+}
+
+func (v LandingPad) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (v LandingPad) format(ident func(Value) string) string {
+	str := "landingpad " + v.Type().String()
+
+	if v.cleanup {
+		str += " cleanup"
+	}
+
+	for _, catch := range v.catches {
+		str += " catch " + valueString(ident, catch)
+	}
+
+	if v.catchAll {
+		str += " catchall"
+	}
+
+	return str
+}
+
+func (_ LandingPad) IsTerminating() bool {
+	return false
+}
@@ -110,7 +110,7 @@ type StringLiteral struct {
 
 func NewStringLiteral(value string, appendNullByte bool) *StringLiteral {
 	if appendNullByte {
-		value += string(0)
+		value += "\x00"
 	}
 
 	return &StringLiteral{
@@ -1,13 +1,20 @@
 package ssa
 
-import "github.com/MovingtoMars/nnvm/types"
+import (
+	"fmt"
+
+	"github.com/MovingtoMars/nnvm/types"
+)
 
 type Load struct {
 	NameHandler
 	ReferenceHandler
 	BlockHandler
+	OperandHandler
 
 	location Value
+	align    int // 0 for the natural alignment of the type
+	volatile bool
 }
 
 func newLoad(location Value) *Load {
@@ -21,7 +28,23 @@ func (v *Load) operands() []*Value {
 }
 
 func (v Load) String() string {
-	return "load " + ValueString(v.location)
+	return v.format(ValueIdentifier)
+}
+
+func (v Load) format(ident func(Value) string) string {
+	str := "load "
+
+	if v.volatile {
+		str += "volatile "
+	}
+
+	str += valueString(ident, v.location)
+
+	if v.align != 0 {
+		str += fmt.Sprintf(", align %d", v.align)
+	}
+
+	return str
 }
 
 func (v Load) Type() types.Type {
@@ -33,6 +56,25 @@ func (v Load) Type() types.Type {
 	return ptr.Element()
 }
 
+// Align returns the alignment of the location in bytes, or 0 for the natural alignment of the type.
+func (v Load) Align() int {
+	return v.align
+}
+
+// SetAlign sets the alignment of the location in bytes. It must be 0 or a power of 2.
+func (v *Load) SetAlign(align int) {
+	v.align = align
+}
+
+// Volatile loads must not be removed, duplicated, or reordered with other volatile accesses.
+func (v Load) IsVolatile() bool {
+	return v.volatile
+}
+
+func (v *Load) SetVolatile(volatile bool) {
+	v.volatile = volatile
+}
+
 func (_ Load) IsTerminating() bool {
 	return false
 }
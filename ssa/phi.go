@@ -5,6 +5,7 @@ import "github.com/MovingtoMars/nnvm/types"
 type Phi struct {
 	ReferenceHandler
 	BlockHandler
+	OperandHandler
 	NameHandler
 
 	typ            types.Type
@@ -22,8 +23,8 @@ func (v *Phi) AddIncoming(val Value, block *Block) {
 	v.incomingValues = append(v.incomingValues, val)
 	v.incomingBlocks = append(v.incomingBlocks, block)
 
-	val.addReference(v)
-	block.addReference(v)
+	index := 2 * (len(v.incomingValues) - 1)
+	v.operandUses = append(v.operandUses, newUse(v, index, val), newUse(v, index+1, block))
 }
 
 func (v Phi) GetIncoming(index int) (Value, *Block) {
@@ -43,13 +44,21 @@ func (v *Phi) RemoveIncoming(index int) {
 		panic("Phi.RemoveIncoming: index out of range")
 	}
 
+	v.operandUses[2*index].value.removeUse(v.operandUses[2*index])
+	v.operandUses[2*index+1].value.removeUse(v.operandUses[2*index+1])
+
+	copy(v.operandUses[2*index:], v.operandUses[2*index+2:])
+	v.operandUses = v.operandUses[:len(v.operandUses)-2]
+	for _, use := range v.operandUses[2*index:] {
+		use.index -= 2
+	}
+
 	slices := []*[]Value{
 		&v.incomingValues,
 		&v.incomingBlocks,
 	}
 
 	for _, slice := range slices {
-		(*slice)[index].removeReference(v)
 		copy((*slice)[index:], (*slice)[index+1:])
 		(*slice) = (*slice)[:len(*slice)-1]
 	}
@@ -61,18 +70,22 @@ func (v Phi) Type() types.Type {
 
 func (v *Phi) operands() []*Value {
 	var ops []*Value
-	for i, val := range v.incomingValues {
-		ops = append(ops, &val, &v.incomingBlocks[i])
+	for i := range v.incomingValues {
+		ops = append(ops, &v.incomingValues[i], &v.incomingBlocks[i])
 	}
 
 	return ops
 }
 
 func (v Phi) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (v Phi) format(ident func(Value) string) string {
 	str := "phi " + v.typ.String() + " "
 
 	for i, val := range v.incomingValues {
-		str += "[ " + ValueIdentifier(val) + ", " + ValueIdentifier(v.incomingBlocks[i]) + " ]"
+		str += "[ " + ident(val) + ", " + ident(v.incomingBlocks[i]) + " ]"
 
 		if i < len(v.incomingValues)-1 {
 			str += ", "
@@ -2,6 +2,7 @@ package ssa
 
 type Ret struct {
 	BlockHandler
+	OperandHandler
 
 	returnValue Value // nil for void return
 }
@@ -17,10 +18,14 @@ func (v *Ret) operands() []*Value {
 }
 
 func (v Ret) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (v Ret) format(ident func(Value) string) string {
 	if v.returnValue == nil {
 		return "ret"
 	}
-	return "ret " + ValueString(v.returnValue)
+	return "ret " + valueString(ident, v.returnValue)
 }
 
 func (_ Ret) IsTerminating() bool { return true }
@@ -15,13 +15,16 @@ type Value interface {
 	Name() string // is empty for unset name
 	SetName(string)
 
+	// Returns one Use for each operand which refers to the value.
+	Uses() []*Use
+
+	// Returns the user of each Use of the value.
 	// Duplicates may exist if the value is referenced more than once in an instruction.
 	References() []Instruction
 
 	// does not update the operand of the instruction
-	addReference(Instruction)
-	removeReference(Instruction)
-	clearReferences()
+	addUse(*Use)
+	removeUse(*Use)
 }
 
 type Instruction interface {
@@ -29,16 +32,31 @@ type Instruction interface {
 	// If the instruction is also a value, the name of the value is not included in the string.
 	String() string
 
+	// Returns the instruction as String does, with the identifier of each operand given by ident.
+	format(ident func(Value) string) string
+
 	IsTerminating() bool
 
 	Block() *Block
 	setBlock(*Block)
 
 	operands() []*Value
+	useList() *[]*Use
 }
 
 func ValueString(val Value) string {
-	return val.Type().String() + " " + ValueIdentifier(val)
+	return valueString(ValueIdentifier, val)
+}
+
+func valueString(ident func(Value) string, val Value) string {
+	return val.Type().String() + " " + ident(val)
+}
+
+// InstrString returns the textual IR representation of the instruction, like String, but with the identifier
+// of each operand given by ident instead of ValueIdentifier. This allows values to be printed with names other
+// than their own without renaming them.
+func InstrString(instr Instruction, ident func(Value) string) string {
+	return instr.format(ident)
 }
 
 func ValueIdentifier(val Value) string {
@@ -52,10 +70,10 @@ func ValueIdentifier(val Value) string {
 	}
 }
 
-func valueListString(values []Value) string {
+func valueListString(ident func(Value) string, values []Value) string {
 	str := ""
 	for i, val := range values {
-		str += ValueString(val)
+		str += valueString(ident, val)
 
 		if i < len(values)-1 {
 			str += ", "
@@ -76,9 +94,14 @@ func GetOperands(instr Instruction) []Value {
 }
 
 func ReplaceOperandFromValue(instr Instruction, value *Value, newValue Value) {
-	(*value).removeReference(instr)
-	(*value) = newValue
-	(*value).addReference(instr)
+	for i, op := range instr.operands() {
+		if op == value {
+			ReplaceOperandFromIndex(instr, i, newValue)
+			return
+		}
+	}
+
+	panic("ReplaceOperand: value is not an operand of the instruction")
 }
 
 func ReplaceOperandFromIndex(instr Instruction, opIndex int, newOp Value) {
@@ -93,24 +116,13 @@ func ReplaceOperandFromIndex(instr Instruction, opIndex int, newOp Value) {
 		panic("ReplaceOperand: opIndex too high")
 	}
 
-	opPtr := ops[opIndex]
-	ReplaceOperandFromValue(instr, opPtr, newOp)
+	setOperand(instr, opIndex, newOp)
 }
 
-// Make sure references are not out-of-date when calling this function.
+// Replaces every operand referring to original with replacement.
 func ReplaceAllValueReferences(original, replacement Value) {
-	origRefs := original.References()
-	for _, instr := range origRefs {
-		for i, op := range instr.operands() {
-
-			if *op == original {
-				*op = replacement
-				break
-			}
-
-			if i == len(instr.operands())-1 {
-				panic("a value reference does not have the value as an operand")
-			}
-		}
+	uses := append([]*Use(nil), original.Uses()...)
+	for _, use := range uses {
+		use.Set(replacement)
 	}
 }
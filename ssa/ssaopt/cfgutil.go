@@ -0,0 +1,78 @@
+package ssaopt
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+// Removes the incoming values from pred in the phis of the block, after pred stops branching to it.
+func removeIncomingFrom(block, pred *ssa.Block) {
+	for _, instr := range block.Instrs() {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+
+		for i := phi.NumIncoming() - 1; i >= 0; i-- {
+			if _, incomingBlock := phi.GetIncoming(i); incomingBlock == pred {
+				phi.RemoveIncoming(i)
+			}
+		}
+	}
+}
+
+// Returns the blocks reachable from the entry block of the function.
+func reachableBlocks(fn *ssa.Function) map[*ssa.Block]bool {
+	reachable := make(map[*ssa.Block]bool, len(fn.Blocks()))
+	worklist := []*ssa.Block{fn.EntryBlock()}
+	reachable[fn.EntryBlock()] = true
+
+	for len(worklist) > 0 {
+		block := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		for _, succ := range block.Successors() {
+			if !reachable[succ] {
+				reachable[succ] = true
+				worklist = append(worklist, succ)
+			}
+		}
+	}
+
+	return reachable
+}
+
+// Erases the blocks unreachable from the entry block, removing them from the phis of the reachable blocks
+// they branch to. Returns true if any blocks were erased.
+func removeUnreachableBlocks(fn *ssa.Function) bool {
+	reachable := reachableBlocks(fn)
+
+	var unreachable []*ssa.Block
+	for _, block := range fn.Blocks() {
+		if !reachable[block] {
+			unreachable = append(unreachable, block)
+		}
+	}
+
+	if len(unreachable) == 0 {
+		return false
+	}
+
+	for _, block := range unreachable {
+		for _, succ := range block.Successors() {
+			if reachable[succ] {
+				removeIncomingFrom(succ, block)
+			}
+		}
+	}
+
+	ssa.EraseBlocks(unreachable...)
+	return true
+}
+
+// Returns the first instruction of the block which is not a phi, or nil if there is none.
+func firstNonPhi(block *ssa.Block) ssa.Instruction {
+	for _, instr := range block.Instrs() {
+		if _, ok := instr.(*ssa.Phi); !ok {
+			return instr
+		}
+	}
+	return nil
+}
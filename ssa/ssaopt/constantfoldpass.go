@@ -1,7 +1,13 @@
 package ssaopt
 
-import "github.com/MovingtoMars/nnvm/ssa"
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+)
 
+// ConstantFoldPass replaces instructions whose operands are literals with the literal they evaluate to, and
+// CondBrs on literal conditions with Brs, until nothing more can be folded. Blocks which become unreachable
+// are then removed.
 type ConstantFoldPass struct {
 }
 
@@ -14,5 +20,58 @@ func (_ ConstantFoldPass) String() string {
 }
 
 func (v ConstantFoldPass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			constantFoldFunction(fn)
+		}
+	}
+}
+
+func constantFoldFunction(fn *ssa.Function) {
+	builder := ssa.NewBuilder()
+
+	for changed := true; changed; {
+		changed = false
+
+		for _, block := range fn.Blocks() {
+			// the block is modified as we go
+			instrs := append([]ssa.Instruction(nil), block.Instrs()...)
+
+			for _, instr := range instrs {
+				if lit := analysis.FoldInstr(instr); lit != nil {
+					ssa.ReplaceAllValueReferences(instr.(ssa.Value), lit)
+					ssa.EraseInstr(instr)
+					changed = true
+				} else if condBr, ok := instr.(*ssa.CondBr); ok && foldCondBr(condBr, builder) {
+					changed = true
+				}
+			}
+		}
+	}
+
+	removeUnreachableBlocks(fn)
+}
+
+// Replaces a CondBr on a literal condition with a Br to the target which is always taken.
+func foldCondBr(condBr *ssa.CondBr, builder *ssa.Builder) bool {
+	ops := ssa.GetOperands(condBr)
+	cond, ok := ops[0].(*ssa.IntLiteral)
+	if !ok {
+		return false
+	}
+
+	target, other := ops[1].(*ssa.Block), ops[2].(*ssa.Block)
+	if cond.LiteralValue().(uint64) == 0 {
+		target, other = other, target
+	}
+
+	block := condBr.Block()
+	builder.SetInsertBeforeInstr(condBr)
+	builder.CreateBr(target)
+	ssa.EraseInstr(condBr)
 
+	if other != target {
+		removeIncomingFrom(other, block)
+	}
+	return true
 }
@@ -0,0 +1,136 @@
+package ssaopt
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+// DCEPass erases blocks unreachable from the entry block, and instructions without side effects whose values
+// are never used, including instructions which only become unused once their users are erased.
+type DCEPass struct {
+}
+
+func NewDCEPass() *DCEPass {
+	return &DCEPass{}
+}
+
+func (_ DCEPass) String() string {
+	return "dce"
+}
+
+func (v DCEPass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			removeUnreachableBlocks(fn)
+			removeDeadInstrs(fn)
+		}
+	}
+}
+
+// ADCEPass is an aggressive variant of DCEPass. Rather than erasing instructions which are unused, it assumes
+// every instruction is dead unless it is reachable through operands from an instruction with side effects or a
+// terminator, so it also erases cycles of unused instructions, such as phis of loop variables which are never read.
+type ADCEPass struct {
+}
+
+func NewADCEPass() *ADCEPass {
+	return &ADCEPass{}
+}
+
+func (_ ADCEPass) String() string {
+	return "adce"
+}
+
+func (v ADCEPass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			removeUnreachableBlocks(fn)
+			aggressiveRemoveDeadInstrs(fn)
+		}
+	}
+}
+
+// Returns true if the instruction can be erased when its value is unused.
+// Terminators, calls, stores, volatile loads and landing pads have side effects, so are never erased.
+func isRemovable(instr ssa.Instruction) bool {
+	switch instr := instr.(type) {
+	case *ssa.BinOp, *ssa.ICmp, *ssa.Convert, *ssa.GEP, *ssa.Phi, *ssa.Alloc, *ssa.ExtractValue:
+		return true
+	case *ssa.Load:
+		return !instr.IsVolatile()
+	default:
+		return false
+	}
+}
+
+func isTriviallyDead(instr ssa.Instruction) bool {
+	return isRemovable(instr) && len(instr.(ssa.Value).Uses()) == 0
+}
+
+// Erases dead instructions, then the instructions they used which became dead. Returns true if any were erased.
+func removeDeadInstrs(fn *ssa.Function) bool {
+	var worklist []ssa.Instruction
+	for _, block := range fn.Blocks() {
+		worklist = append(worklist, block.Instrs()...)
+	}
+
+	changed := false
+	for len(worklist) > 0 {
+		instr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		// instructions can be added to the worklist again after being erased
+		if instr.Block() == nil || !isTriviallyDead(instr) {
+			continue
+		}
+
+		ops := ssa.GetOperands(instr)
+		ssa.EraseInstr(instr)
+		changed = true
+
+		for _, op := range ops {
+			if opInstr, ok := op.(ssa.Instruction); ok && opInstr.Block() != nil {
+				worklist = append(worklist, opInstr)
+			}
+		}
+	}
+
+	return changed
+}
+
+// Marks the instructions which can't be removed as live, then every instruction they use, and erases the rest.
+// Returns true if any were erased.
+func aggressiveRemoveDeadInstrs(fn *ssa.Function) bool {
+	live := make(map[ssa.Instruction]bool)
+	var worklist []ssa.Instruction
+
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			if !isRemovable(instr) {
+				live[instr] = true
+				worklist = append(worklist, instr)
+			}
+		}
+	}
+
+	for len(worklist) > 0 {
+		instr := worklist[len(worklist)-1]
+		worklist = worklist[:len(worklist)-1]
+
+		for _, op := range ssa.GetOperands(instr) {
+			if opInstr, ok := op.(ssa.Instruction); ok && !live[opInstr] {
+				live[opInstr] = true
+				worklist = append(worklist, opInstr)
+			}
+		}
+	}
+
+	var dead []ssa.Instruction
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			if !live[instr] {
+				dead = append(dead, instr)
+			}
+		}
+	}
+
+	ssa.EraseInstrs(dead...)
+	return len(dead) > 0
+}
@@ -0,0 +1,278 @@
+package ssaopt
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// GVNPass performs global value numbering. Walking the dominator tree, BinOps, ICmps, Converts and GEPs which
+// compute the same value as an instruction dominating them are replaced with it. The operands of commutative
+// operations are put in a canonical order first, so x + y and y + x are found to be the same.
+//
+// If an alias analysis is given, loads which must read a value already loaded from or stored to the same location
+// are also replaced, as long as no store or call in between may write to it. Memory is only assumed to be unchanged
+// on entry to a block if its immediate dominator is its only predecessor.
+type GVNPass struct {
+	aa     analysis.AliasAnalysis
+	layout analysis.DataLayout
+}
+
+// NewGVNPass creates a GVN pass. Loads are only eliminated if aa is not nil.
+// The layout gives the sizes of memory accesses for the alias analysis, which are unknown if it is nil.
+func NewGVNPass(aa analysis.AliasAnalysis, layout analysis.DataLayout) *GVNPass {
+	return &GVNPass{
+		aa:     aa,
+		layout: layout,
+	}
+}
+
+func (_ GVNPass) String() string {
+	return "gvn"
+}
+
+func (v GVNPass) Run(mod *ssa.Module) {
+	v.RunWithAnalyses(mod, analysis.NewAnalysisManager())
+}
+
+func (v GVNPass) RunWithAnalyses(mod *ssa.Module, am *analysis.AnalysisManager) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newGVN(fn, v.aa, v.layout, am.DominatorTree(fn)).run()
+		}
+	}
+}
+
+// Only instructions without side effects are removed, so the control flow and calls are unchanged.
+func (_ GVNPass) Preserved() []analysis.AnalysisID {
+	return []analysis.AnalysisID{
+		analysis.CFGAnalysis,
+		analysis.DominatorTreeAnalysis,
+		analysis.PostDominatorTreeAnalysis,
+		analysis.LoopInfoAnalysis,
+		analysis.CallGraphAnalysis,
+	}
+}
+
+// A value known to be in memory at ptr, from a load or a store.
+type gvnAvailableValue struct {
+	ptr  ssa.Value
+	val  ssa.Value
+	size int
+}
+
+type gvn struct {
+	fn      *ssa.Function
+	aa      analysis.AliasAnalysis
+	layout  analysis.DataLayout
+	domTree *analysis.DominatorTree
+
+	numbers     map[ssa.Value]int    // identifies the operands in expression keys
+	expressions map[string]ssa.Value // expression key -> an instruction computing it which dominates the current block
+
+	availableAtEnd map[*ssa.Block][]gvnAvailableValue
+}
+
+func newGVN(fn *ssa.Function, aa analysis.AliasAnalysis, layout analysis.DataLayout, domTree *analysis.DominatorTree) *gvn {
+	return &gvn{
+		fn:             fn,
+		aa:             aa,
+		layout:         layout,
+		domTree:        domTree,
+		numbers:        make(map[ssa.Value]int),
+		expressions:    make(map[string]ssa.Value),
+		availableAtEnd: make(map[*ssa.Block][]gvnAvailableValue),
+	}
+}
+
+func (v *gvn) run() {
+	v.visit(v.domTree.Root())
+}
+
+// Visits the block and then the blocks it dominates. The expressions of the block are only available while
+// visiting the blocks it dominates.
+func (v *gvn) visit(node *analysis.DominatorTreeNode) {
+	block := node.Block()
+	available := v.availableAtStart(node)
+
+	var added []string
+	for _, instr := range append([]ssa.Instruction(nil), block.Instrs()...) {
+		switch instr := instr.(type) {
+		case *ssa.BinOp, *ssa.ICmp, *ssa.Convert, *ssa.GEP:
+			key := v.key(instr)
+			if leader, ok := v.expressions[key]; ok {
+				ssa.ReplaceAllValueReferences(instr.(ssa.Value), leader)
+				ssa.EraseInstr(instr)
+			} else {
+				v.expressions[key] = instr.(ssa.Value)
+				added = append(added, key)
+			}
+
+		case *ssa.Load:
+			available = v.visitLoad(instr, available)
+
+		case *ssa.Store:
+			available = v.visitStore(instr, available)
+
+		case *ssa.Call, *ssa.Invoke:
+			available = v.removeModified(instr, available)
+		}
+	}
+	v.availableAtEnd[block] = available
+
+	for _, child := range node.Children() {
+		v.visit(child)
+	}
+
+	for _, key := range added {
+		delete(v.expressions, key)
+	}
+}
+
+// Returns true if the operands of the operation can be swapped without changing its result.
+func isCommutative(op ssa.BinOpType) bool {
+	switch op {
+	case ssa.BinOpAdd, ssa.BinOpMul, ssa.BinOpAnd, ssa.BinOpOr, ssa.BinOpXor, ssa.BinOpFAdd, ssa.BinOpFMul:
+		return true
+	default:
+		return false
+	}
+}
+
+// Returns a string identifying an operand. Int and float literals with the same type and value have the same key.
+func (v *gvn) operandKey(op ssa.Value) string {
+	switch op := op.(type) {
+	case *ssa.IntLiteral, *ssa.FloatLiteral:
+		return fmt.Sprintf("%s %d", op.Type(), op.(ssa.Literal).LiteralValue())
+	}
+
+	num, ok := v.numbers[op]
+	if !ok {
+		num = len(v.numbers)
+		v.numbers[op] = num
+	}
+	return fmt.Sprintf("#%d", num)
+}
+
+// Returns a string identifying the value computed by the instruction, so instructions computing the same value
+// have the same key.
+func (v *gvn) key(instr ssa.Instruction) string {
+	ops := ssa.GetOperands(instr)
+	opKeys := make([]string, len(ops))
+	for i, op := range ops {
+		opKeys[i] = v.operandKey(op)
+	}
+
+	var kind string
+	switch instr := instr.(type) {
+	case *ssa.BinOp:
+		kind = instr.BinOpType().String()
+		if isCommutative(instr.BinOpType()) {
+			sort.Strings(opKeys)
+		}
+
+	case *ssa.ICmp:
+		pred := instr.Predicate()
+		if opKeys[0] > opKeys[1] {
+			opKeys[0], opKeys[1] = opKeys[1], opKeys[0]
+			pred = analysis.SwappedPredicate(pred)
+		}
+		kind = pred.String()
+
+	case *ssa.Convert:
+		kind = instr.ConvertType().String()
+
+	case *ssa.GEP:
+		kind = "GEP"
+
+	default:
+		panic("unim")
+	}
+
+	return kind + " " + instr.(ssa.Value).Type().String() + " (" + strings.Join(opKeys, ", ") + ")"
+}
+
+// Returns the values in memory at the start of the block. They are those at the end of its immediate dominator
+// if it is the only predecessor, as no other path can reach the block.
+func (v *gvn) availableAtStart(node *analysis.DominatorTreeNode) []gvnAvailableValue {
+	if v.aa == nil {
+		return nil
+	}
+
+	idom := node.ImmediateDominator()
+	if idom == nil || singlePredecessor(node.Block()) != idom.Block() {
+		return nil
+	}
+	return append([]gvnAvailableValue(nil), v.availableAtEnd[idom.Block()]...)
+}
+
+// Returns the number of bytes accessed by a load from or store to the pointer, or analysis.UnknownSize if
+// there is no layout.
+func accessSize(layout analysis.DataLayout, ptr ssa.Value) int {
+	if layout == nil {
+		return analysis.UnknownSize
+	}
+	return layout.TypeStoreSizeInBits(ptr.Type().(*types.Pointer).Element()) / 8
+}
+
+// Replaces the load with a value already in memory at the same location, or makes its value available.
+func (v *gvn) visitLoad(load *ssa.Load, available []gvnAvailableValue) []gvnAvailableValue {
+	if v.aa == nil || load.IsVolatile() {
+		return available
+	}
+
+	ptr := ssa.GetOperands(load)[0]
+	size := accessSize(v.layout, ptr)
+
+	for _, avail := range available {
+		if avail.val.Type().Equals(load.Type()) && v.aa.Alias(ptr, size, avail.ptr, avail.size) == analysis.MustAlias {
+			ssa.ReplaceAllValueReferences(load, avail.val)
+			ssa.EraseInstr(load)
+			return available
+		}
+	}
+
+	return append(available, gvnAvailableValue{ptr: ptr, val: load, size: size})
+}
+
+// Removes the values the store may overwrite, and makes the stored value available.
+func (v *gvn) visitStore(store *ssa.Store, available []gvnAvailableValue) []gvnAvailableValue {
+	if v.aa == nil {
+		return available
+	}
+
+	ops := ssa.GetOperands(store)
+	ptr, val := ops[0], ops[1]
+	size := accessSize(v.layout, ptr)
+
+	remaining := available[:0]
+	for _, avail := range available {
+		if v.aa.Alias(ptr, size, avail.ptr, avail.size) == analysis.NoAlias {
+			remaining = append(remaining, avail)
+		}
+	}
+
+	if store.IsVolatile() {
+		return remaining
+	}
+	return append(remaining, gvnAvailableValue{ptr: ptr, val: val, size: size})
+}
+
+// Removes the values the call may overwrite.
+func (v *gvn) removeModified(call ssa.Instruction, available []gvnAvailableValue) []gvnAvailableValue {
+	if v.aa == nil {
+		return available
+	}
+
+	remaining := available[:0]
+	for _, avail := range available {
+		if v.aa.ModRef(call, avail.ptr, avail.size)&analysis.Mod == 0 {
+			remaining = append(remaining, avail)
+		}
+	}
+	return remaining
+}
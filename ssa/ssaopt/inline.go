@@ -0,0 +1,337 @@
+package ssaopt
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// DefaultInlineThreshold is the largest cost of a function which is inlined by default.
+const DefaultInlineThreshold = 50
+
+// InlinePass replaces calls with copies of the body of the called function. A function is inlined if its
+// cost, roughly its number of instructions, is no more than the threshold, unless its inline hint says otherwise.
+// Functions are processed bottom-up over the SCCs of the call graph, so callees have already had calls inlined
+// into them when they are inlined. Calls to functions in the same SCC as the caller are never inlined.
+//
+// Only calls are inlined, not invokes, and functions which are variadic, contain invokes or landing pads, or
+// allocate a dynamic amount of stack memory are never inlined. Allocs in the entry block of an inlined function
+// are moved to the entry block of the caller.
+type InlinePass struct {
+	threshold int
+}
+
+func NewInlinePass(threshold int) *InlinePass {
+	return &InlinePass{
+		threshold: threshold,
+	}
+}
+
+func (_ InlinePass) String() string {
+	return "inline"
+}
+
+func (v InlinePass) Run(mod *ssa.Module) {
+	v.RunWithAnalyses(mod, analysis.NewAnalysisManager())
+}
+
+func (v InlinePass) RunWithAnalyses(mod *ssa.Module, am *analysis.AnalysisManager) {
+	callGraph := am.CallGraph(mod)
+	builder := ssa.NewBuilder()
+
+	for _, scc := range callGraph.SCCs() {
+		inSCC := make(map[*ssa.Function]bool)
+		for _, node := range scc {
+			if fn := node.Function(); fn != nil {
+				inSCC[fn] = true
+			}
+		}
+
+		for _, node := range scc {
+			fn := node.Function()
+			if fn == nil || fn.IsPrototype() {
+				continue
+			}
+
+			for _, call := range callSites(fn) {
+				if call.Block() == nil {
+					continue // erased with the blocks left unreachable by an earlier inlining
+				}
+
+				callee, ok := ssa.GetOperands(call)[0].(*ssa.Function)
+				if ok && !inSCC[callee] && v.shouldInline(callee) && canInline(call, callee) {
+					inlineCall(call, callee, builder)
+				}
+			}
+		}
+	}
+}
+
+// Returns the calls in the function.
+func callSites(fn *ssa.Function) []*ssa.Call {
+	var calls []*ssa.Call
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			if call, ok := instr.(*ssa.Call); ok {
+				calls = append(calls, call)
+			}
+		}
+	}
+	return calls
+}
+
+// Returns an estimate of the size of the function: the number of instructions, not counting phis and
+// unconditional branches, which often disappear once the function is inlined.
+func inlineCost(fn *ssa.Function) int {
+	cost := 0
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			switch instr.(type) {
+			case *ssa.Phi, *ssa.Br:
+			default:
+				cost++
+			}
+		}
+	}
+	return cost
+}
+
+func (v InlinePass) shouldInline(callee *ssa.Function) bool {
+	switch callee.InlineHint() {
+	case ssa.InlineAlways:
+		return true
+	case ssa.InlineNever:
+		return false
+	default:
+		return inlineCost(callee) <= v.threshold
+	}
+}
+
+// Returns true if the call can be replaced with the body of the callee.
+func canInline(call *ssa.Call, callee *ssa.Function) bool {
+	if callee.IsPrototype() || callee.Type().(*types.Signature).Variadic() {
+		return false
+	}
+
+	reachable := reachableBlocks(callee)
+	returns := false
+	for _, block := range callee.Blocks() {
+		for _, instr := range block.Instrs() {
+			switch instr := instr.(type) {
+			case *ssa.Invoke, *ssa.LandingPad:
+				return false
+			case *ssa.Alloc:
+				if instr.IsDynamic() {
+					return false
+				}
+			case *ssa.Call:
+				if _, ok := ssa.GetOperands(instr)[0].(*ssa.Function); !ok {
+					return false // can't be copied by the builder
+				}
+			case *ssa.Ret:
+				if reachable[block] {
+					returns = true
+				}
+			}
+		}
+	}
+
+	// the uses of the call's value can't be replaced if the callee never returns
+	return returns || len(call.Uses()) == 0
+}
+
+// Replaces the call with a copy of the body of the callee. The block of the call is split after the call, and
+// the copies of the callee's rets branch to the second half, where a phi merges their values if there are more
+// than one. Blocks of the callee unreachable from its entry block are not copied. If the callee never returns,
+// the second half and the blocks only it leads to are removed.
+func inlineCall(call *ssa.Call, callee *ssa.Function, builder *ssa.Builder) {
+	block := call.Block()
+	caller := block.Function()
+	args := ssa.GetOperands(call)[1:]
+
+	cont := caller.AddBlockAfter(callee.Name()+".return", block)
+	index := block.InstrIndex(call)
+	for _, instr := range append([]ssa.Instruction(nil), block.Instrs()[index+1:]...) {
+		ssa.MoveInstrToBlockEnd(instr, cont)
+	}
+
+	// the phis of the successors now have incoming values from the second half
+	for _, use := range append([]*ssa.Use(nil), block.Uses()...) {
+		if _, ok := use.User().(*ssa.Phi); ok {
+			use.Set(cont)
+		}
+	}
+
+	// callee value -> copy
+	values := make(map[ssa.Value]ssa.Value)
+	for i, par := range callee.Parameters() {
+		values[par] = args[i]
+	}
+	mapValue := func(val ssa.Value) ssa.Value {
+		if mapped, ok := values[val]; ok {
+			return mapped
+		}
+		return val // literals, globals and functions
+	}
+
+	// the blocks are copied in dominator tree order, so every operand other than a phi incoming value is copied
+	// before its users
+	order := analysis.DominatorTreePreorder(callee)
+	reachable := make(map[*ssa.Block]bool, len(order))
+	for _, b := range order {
+		reachable[b] = true
+	}
+	for _, b := range callee.Blocks() {
+		if reachable[b] {
+			values[b] = caller.AddBlockBefore(callee.Name()+"."+b.Name(), cont)
+		}
+	}
+
+	var phis []*ssa.Phi
+	var retVals []ssa.Value
+	var retBlocks []*ssa.Block
+	returns := false
+
+	for _, b := range order {
+		copyBlock := values[b].(*ssa.Block)
+		builder.SetInsertAtBlockEnd(copyBlock)
+
+		for _, instr := range b.Instrs() {
+			if ret, ok := instr.(*ssa.Ret); ok {
+				if val := ssa.GetOperands(ret)[0]; val != nil {
+					retVals = append(retVals, mapValue(val))
+					retBlocks = append(retBlocks, copyBlock)
+				}
+				builder.CreateBr(cont)
+				returns = true
+				continue
+			}
+
+			if phi, ok := instr.(*ssa.Phi); ok {
+				phis = append(phis, phi)
+			}
+
+			clone := copyInstr(instr, mapValue, builder)
+			if val, ok := instr.(ssa.Value); ok {
+				values[val] = clone.(ssa.Value)
+			}
+		}
+	}
+
+	for _, phi := range phis {
+		clone := values[phi].(*ssa.Phi)
+		for i := 0; i < phi.NumIncoming(); i++ {
+			if val, pred := phi.GetIncoming(i); reachable[pred] {
+				clone.AddIncoming(mapValue(val), values[pred].(*ssa.Block))
+			}
+		}
+	}
+
+	switch len(retVals) {
+	case 0:
+		// the call's value is void or unused
+	case 1:
+		ssa.ReplaceAllValueReferences(call, retVals[0])
+	default:
+		builder.SetInsertAtBlockEnd(cont)
+		phi := builder.CreatePhi(call.Type(), call.Name())
+		for i, val := range retVals {
+			phi.AddIncoming(val, retBlocks[i])
+		}
+		ssa.MoveInstrBefore(phi, cont.FirstInstr())
+		ssa.ReplaceAllValueReferences(call, phi)
+	}
+
+	builder.SetInsertBeforeInstr(call)
+	builder.CreateBr(values[callee.EntryBlock()].(*ssa.Block))
+	ssa.EraseInstr(call)
+
+	// allocs in the entry block are executed once per call, so can be executed once per call of the caller instead
+	first := caller.EntryBlock().FirstInstr()
+	for _, instr := range append([]ssa.Instruction(nil), values[callee.EntryBlock()].(*ssa.Block).Instrs()...) {
+		if alloc, ok := instr.(*ssa.Alloc); ok {
+			ssa.MoveInstrBefore(alloc, first)
+		}
+	}
+
+	if !returns {
+		removeUnreachableBlocks(caller)
+	}
+}
+
+// Creates a copy of the instruction with the builder, with its operands mapped. Phis are created without
+// incoming values. Panics for rets, invokes, landing pads and calls of values other than functions.
+func copyInstr(instr ssa.Instruction, mapValue func(ssa.Value) ssa.Value, builder *ssa.Builder) ssa.Instruction {
+	var ops []ssa.Value
+	for _, op := range ssa.GetOperands(instr) {
+		ops = append(ops, mapValue(op))
+	}
+
+	name := ""
+	if val, ok := instr.(ssa.Value); ok {
+		name = val.Name()
+	}
+
+	switch instr := instr.(type) {
+	case *ssa.BinOp:
+		return builder.CreateBinOp(ops[0], ops[1], instr.BinOpType(), name)
+
+	case *ssa.ICmp:
+		return builder.CreateICmp(ops[0], ops[1], instr.Predicate(), name)
+
+	case *ssa.Convert:
+		return builder.CreateConvert(ops[0], instr.Type(), instr.ConvertType(), name)
+
+	case *ssa.GEP:
+		return builder.CreateGEP(ops[0], ops[1:], name)
+
+	case *ssa.ExtractValue:
+		return builder.CreateExtractValue(ops[0], instr.Index(), name)
+
+	case *ssa.Alloc:
+		elem := instr.Type().(*types.Pointer).Element()
+
+		var alloc *ssa.Alloc
+		if ops[0] == nil {
+			alloc = builder.CreateAlloc(elem, name)
+		} else {
+			alloc = builder.CreateArrayAlloc(elem, ops[0], name)
+		}
+		alloc.SetAlign(instr.Align())
+		return alloc
+
+	case *ssa.Load:
+		load := builder.CreateLoad(ops[0], name)
+		load.SetAlign(instr.Align())
+		load.SetVolatile(instr.IsVolatile())
+		return load
+
+	case *ssa.Store:
+		store := builder.CreateStore(ops[0], ops[1])
+		store.SetAlign(instr.Align())
+		store.SetVolatile(instr.IsVolatile())
+		return store
+
+	case *ssa.Call:
+		fn, ok := ops[0].(*ssa.Function)
+		if !ok {
+			panic("unim")
+		}
+		return builder.CreateCall(fn, ops[1:], name)
+
+	case *ssa.Phi:
+		return builder.CreatePhi(instr.Type(), name)
+
+	case *ssa.Br:
+		return builder.CreateBr(ops[0].(*ssa.Block))
+
+	case *ssa.CondBr:
+		return builder.CreateCondBr(ops[0], ops[1].(*ssa.Block), ops[2].(*ssa.Block))
+
+	case *ssa.Unreachable:
+		return builder.CreateUnreachable()
+
+	default:
+		panic("unim")
+	}
+}
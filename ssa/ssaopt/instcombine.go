@@ -0,0 +1,318 @@
+package ssaopt
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// InstCombinePass applies peephole simplifications to BinOps, ICmps and Converts, such as folding x + 0 to x,
+// x * 2^k to x << k, a zext of a zext to a single zext, and a comparison of a zero-extended i1 with a literal to
+// the i1 itself. Literal operands of commutative operations and comparisons are moved to the right, so only that
+// form needs to be matched. Instructions are simplified again whenever one of their operands is, until nothing
+// changes, and then the instructions left unused are erased.
+type InstCombinePass struct {
+}
+
+func NewInstCombinePass() *InstCombinePass {
+	return &InstCombinePass{}
+}
+
+func (_ InstCombinePass) String() string {
+	return "instcombine"
+}
+
+func (v InstCombinePass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newInstCombine(fn).run()
+		}
+	}
+}
+
+// Only instructions without side effects are added and removed, so the control flow and calls are unchanged.
+func (_ InstCombinePass) Preserved() []analysis.AnalysisID {
+	return []analysis.AnalysisID{
+		analysis.CFGAnalysis,
+		analysis.DominatorTreeAnalysis,
+		analysis.PostDominatorTreeAnalysis,
+		analysis.LoopInfoAnalysis,
+		analysis.CallGraphAnalysis,
+	}
+}
+
+type instCombine struct {
+	fn       *ssa.Function
+	builder  *ssa.Builder
+	worklist []ssa.Instruction
+}
+
+func newInstCombine(fn *ssa.Function) *instCombine {
+	return &instCombine{
+		fn:      fn,
+		builder: ssa.NewBuilder(),
+	}
+}
+
+func (v *instCombine) run() {
+	// in reverse, so the instructions are popped in order
+	blocks := v.fn.Blocks()
+	for i := len(blocks) - 1; i >= 0; i-- {
+		instrs := blocks[i].Instrs()
+		for j := len(instrs) - 1; j >= 0; j-- {
+			v.worklist = append(v.worklist, instrs[j])
+		}
+	}
+
+	for len(v.worklist) > 0 {
+		instr := v.worklist[len(v.worklist)-1]
+		v.worklist = v.worklist[:len(v.worklist)-1]
+
+		if instr.Block() == nil {
+			continue // erased after being added again
+		}
+
+		res := v.simplify(instr)
+		if res == nil {
+			continue
+		}
+
+		for _, use := range instr.(ssa.Value).Uses() {
+			v.worklist = append(v.worklist, use.User())
+		}
+		if resInstr, ok := res.(ssa.Instruction); ok {
+			v.worklist = append(v.worklist, resInstr)
+		}
+
+		ssa.ReplaceAllValueReferences(instr.(ssa.Value), res)
+		ssa.EraseInstr(instr)
+	}
+
+	removeDeadInstrs(v.fn)
+}
+
+// Returns the value the instruction can be replaced with, which may be a new instruction inserted before it,
+// or nil if it can't be simplified.
+func (v *instCombine) simplify(instr ssa.Instruction) ssa.Value {
+	if lit := analysis.FoldInstr(instr); lit != nil {
+		return lit
+	}
+
+	v.builder.SetInsertBeforeInstr(instr)
+
+	switch instr := instr.(type) {
+	case *ssa.BinOp:
+		return v.simplifyBinOp(instr)
+	case *ssa.ICmp:
+		return v.simplifyICmp(instr)
+	case *ssa.Convert:
+		return v.simplifyConvert(instr)
+	default:
+		return nil
+	}
+}
+
+// Returns the value of an int literal of at most 64 bits.
+func intLiteral(val ssa.Value) (uint64, bool) {
+	lit, ok := val.(*ssa.IntLiteral)
+	if !ok || lit.Type().(*types.Int).Width() > 64 {
+		return 0, false
+	}
+	return lit.LiteralValue().(uint64), true
+}
+
+func isIntLiteral(val ssa.Value, x uint64) bool {
+	lit, ok := intLiteral(val)
+	return ok && lit == x
+}
+
+// Returns k if the value is a literal 2^k with k > 0.
+func powerOfTwoLiteral(val ssa.Value) (uint64, bool) {
+	lit, ok := intLiteral(val)
+	if !ok || lit < 2 || lit&(lit-1) != 0 {
+		return 0, false
+	}
+
+	k := uint64(0)
+	for lit > 1 {
+		lit >>= 1
+		k++
+	}
+	return k, true
+}
+
+func (v *instCombine) simplifyBinOp(instr *ssa.BinOp) ssa.Value {
+	ops := ssa.GetOperands(instr)
+	x, y := ops[0], ops[1]
+	op := instr.BinOpType()
+
+	typ, ok := instr.Type().(*types.Int)
+	if !ok {
+		return nil // float identities don't hold for negative zero and NaN
+	}
+
+	_, xLit := x.(*ssa.IntLiteral)
+	_, yLit := y.(*ssa.IntLiteral)
+	if xLit && !yLit && isCommutative(op) {
+		return v.builder.CreateBinOp(y, x, op, instr.Name())
+	}
+
+	switch op {
+	case ssa.BinOpAdd, ssa.BinOpOr, ssa.BinOpXor, ssa.BinOpShl, ssa.BinOpLShr, ssa.BinOpAShr:
+		if isIntLiteral(y, 0) {
+			return x
+		}
+
+	case ssa.BinOpSub:
+		if isIntLiteral(y, 0) {
+			return x
+		}
+
+	case ssa.BinOpMul:
+		if isIntLiteral(y, 0) {
+			return y
+		} else if isIntLiteral(y, 1) {
+			return x
+		} else if k, ok := powerOfTwoLiteral(y); ok {
+			return v.builder.CreateBinOp(x, ssa.NewIntLiteral(k, typ), ssa.BinOpShl, instr.Name())
+		}
+
+	case ssa.BinOpSDiv:
+		if isIntLiteral(y, 1) {
+			return x
+		}
+
+	case ssa.BinOpUDiv:
+		if isIntLiteral(y, 1) {
+			return x
+		} else if k, ok := powerOfTwoLiteral(y); ok {
+			return v.builder.CreateBinOp(x, ssa.NewIntLiteral(k, typ), ssa.BinOpLShr, instr.Name())
+		}
+
+	case ssa.BinOpURem:
+		if _, ok := powerOfTwoLiteral(y); ok {
+			mask, _ := intLiteral(y)
+			return v.builder.CreateBinOp(x, ssa.NewIntLiteral(mask-1, typ), ssa.BinOpAnd, instr.Name())
+		}
+
+	case ssa.BinOpAnd:
+		if isIntLiteral(y, 0) {
+			return y
+		}
+	}
+
+	if x == y {
+		switch op {
+		case ssa.BinOpSub, ssa.BinOpXor:
+			return ssa.NewIntLiteral(0, typ)
+		case ssa.BinOpAnd, ssa.BinOpOr:
+			return x
+		}
+	}
+
+	return nil
+}
+
+func (v *instCombine) simplifyICmp(instr *ssa.ICmp) ssa.Value {
+	ops := ssa.GetOperands(instr)
+	x, y := ops[0], ops[1]
+	pred := instr.Predicate()
+
+	_, xLit := x.(*ssa.IntLiteral)
+	_, yLit := y.(*ssa.IntLiteral)
+	if xLit && !yLit {
+		return v.builder.CreateICmp(y, x, analysis.SwappedPredicate(pred), instr.Name())
+	}
+
+	i1 := types.NewInt(1)
+
+	if x == y {
+		switch pred {
+		case ssa.IntEQ, ssa.IntUGE, ssa.IntULE, ssa.IntSGE, ssa.IntSLE:
+			return ssa.NewIntLiteral(1, i1)
+		default:
+			return ssa.NewIntLiteral(0, i1)
+		}
+	}
+
+	lit, ok := intLiteral(y)
+	if !ok || (pred != ssa.IntEQ && pred != ssa.IntNEQ) {
+		return nil
+	}
+
+	// x == 1 and x != 0 are x itself for an i1
+	if x.Type().Equals(i1) {
+		if (pred == ssa.IntEQ) == (lit == 1) {
+			return x
+		}
+		return nil
+	}
+
+	// a zero-extended i1 is only ever 0 or 1, so comparing it with a literal compares the i1
+	zext, ok := x.(*ssa.Convert)
+	if !ok || zext.ConvertType() != ssa.ConvertZExt {
+		return nil
+	}
+	b := ssa.GetOperands(zext)[0]
+	if !b.Type().Equals(i1) {
+		return nil
+	}
+
+	switch lit {
+	case 0, 1:
+		if (pred == ssa.IntEQ) == (lit == 1) {
+			return b
+		}
+		return v.builder.CreateICmp(b, ssa.NewIntLiteral(0, i1), ssa.IntEQ, instr.Name())
+	default:
+		if pred == ssa.IntEQ {
+			return ssa.NewIntLiteral(0, i1)
+		}
+		return ssa.NewIntLiteral(1, i1)
+	}
+}
+
+func (v *instCombine) simplifyConvert(instr *ssa.Convert) ssa.Value {
+	x := ssa.GetOperands(instr)[0]
+	typ := instr.Type()
+
+	if instr.ConvertType() == ssa.ConvertBitcast && x.Type().Equals(typ) {
+		return x
+	}
+
+	inner, ok := x.(*ssa.Convert)
+	if !ok {
+		return nil
+	}
+	src := ssa.GetOperands(inner)[0]
+
+	switch outer, innerType := instr.ConvertType(), inner.ConvertType(); {
+	case outer == ssa.ConvertBitcast && innerType == ssa.ConvertBitcast:
+		if src.Type().Equals(typ) {
+			return src
+		}
+		return v.builder.CreateConvert(src, typ, ssa.ConvertBitcast, instr.Name())
+
+	case outer == ssa.ConvertZExt && innerType == ssa.ConvertZExt,
+		outer == ssa.ConvertSExt && innerType == ssa.ConvertSExt,
+		outer == ssa.ConvertTrunc && innerType == ssa.ConvertTrunc:
+		return v.builder.CreateConvert(src, typ, outer, instr.Name())
+
+	case outer == ssa.ConvertSExt && innerType == ssa.ConvertZExt:
+		// the sign bit of a zero-extended value is always 0
+		return v.builder.CreateConvert(src, typ, ssa.ConvertZExt, instr.Name())
+
+	case outer == ssa.ConvertTrunc && (innerType == ssa.ConvertZExt || innerType == ssa.ConvertSExt):
+		srcWidth, width := src.Type().(*types.Int).Width(), typ.(*types.Int).Width()
+		switch {
+		case width == srcWidth:
+			return src
+		case width < srcWidth:
+			return v.builder.CreateConvert(src, typ, ssa.ConvertTrunc, instr.Name())
+		default:
+			return v.builder.CreateConvert(src, typ, innerType, instr.Name())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,409 @@
+package ssaopt
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// LICMPass performs loop-invariant code motion. BinOps, ICmps, Converts and GEPs in a loop whose operands are all
+// defined outside of it are hoisted into the preheader of the loop, which is created if the loop doesn't have one.
+// Inner loops are processed first, so instructions can be hoisted out of several loops. Divisions are only hoisted
+// if their divisor is a literal which can't trap.
+//
+// If an alias analysis is given, loads from loop-invariant pointers which nothing in the loop may write to are
+// also hoisted, and stores to loop-invariant pointers which nothing else in the loop may access are sunk into the
+// blocks the loop exits to.
+type LICMPass struct {
+	aa     analysis.AliasAnalysis
+	layout analysis.DataLayout
+}
+
+// NewLICMPass creates an LICM pass. Loads and stores are only moved if aa is not nil.
+// The layout gives the sizes of memory accesses for the alias analysis, which are unknown if it is nil.
+func NewLICMPass(aa analysis.AliasAnalysis, layout analysis.DataLayout) *LICMPass {
+	return &LICMPass{
+		aa:     aa,
+		layout: layout,
+	}
+}
+
+func (_ LICMPass) String() string {
+	return "licm"
+}
+
+func (v LICMPass) Run(mod *ssa.Module) {
+	v.RunWithAnalyses(mod, analysis.NewAnalysisManager())
+}
+
+func (v LICMPass) RunWithAnalyses(mod *ssa.Module, am *analysis.AnalysisManager) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newLICM(fn, v.aa, v.layout, am).run()
+		}
+	}
+}
+
+// Preheaders may be added, but calls are never moved.
+func (_ LICMPass) Preserved() []analysis.AnalysisID {
+	return []analysis.AnalysisID{analysis.CallGraphAnalysis}
+}
+
+type licm struct {
+	fn      *ssa.Function
+	aa      analysis.AliasAnalysis
+	layout  analysis.DataLayout
+	am      *analysis.AnalysisManager
+	domTree *analysis.DominatorTree
+	builder *ssa.Builder
+}
+
+func newLICM(fn *ssa.Function, aa analysis.AliasAnalysis, layout analysis.DataLayout, am *analysis.AnalysisManager) *licm {
+	return &licm{
+		fn:      fn,
+		aa:      aa,
+		layout:  layout,
+		am:      am,
+		builder: ssa.NewBuilder(),
+	}
+}
+
+func (v *licm) run() {
+	if v.insertPreheaders() {
+		v.am.InvalidateFunction(v.fn, analysis.CallGraphAnalysis)
+	}
+
+	// instructions are only moved between existing blocks from here on, so the analyses stay valid
+	v.domTree = v.am.DominatorTree(v.fn)
+	loops := v.am.LoopInfo(v.fn).Loops()
+
+	// inner loops are after the loops they are nested in
+	for i := len(loops) - 1; i >= 0; i-- {
+		loop := loops[i]
+
+		preheader := loop.Preheader()
+		if preheader == nil {
+			continue
+		}
+
+		v.hoist(loop, preheader)
+		if v.aa != nil {
+			v.sinkStores(loop)
+		}
+	}
+}
+
+// Creates a preheader for each loop which doesn't have one. Returns true if any were created.
+func (v *licm) insertPreheaders() bool {
+	changed := false
+	for _, loop := range v.am.LoopInfo(v.fn).Loops() {
+		if loop.Preheader() == nil && v.insertPreheader(loop) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// Creates a block just before the header of the loop which branches to the header, and redirects the branches
+// to the header from outside the loop to it. If the header has more than one predecessor outside the loop, the
+// incoming values from them are merged by phis in the new block. Returns false if the header is the entry block
+// or begins with a landing pad, as nothing can branch to it from a new block.
+func (v *licm) insertPreheader(loop *analysis.Loop) bool {
+	header := loop.Header()
+	if header.IsEntry() {
+		return false
+	}
+	if _, ok := firstNonPhi(header).(*ssa.LandingPad); ok {
+		return false
+	}
+
+	var outside []*ssa.Block
+	seen := make(map[*ssa.Block]bool)
+	for _, pred := range header.Predecessors() {
+		if !loop.Contains(pred) && !seen[pred] {
+			seen[pred] = true
+			outside = append(outside, pred)
+		}
+	}
+
+	preheader := v.fn.AddBlockBefore(header.Name()+".preheader", header)
+	v.builder.SetInsertAtBlockEnd(preheader)
+
+	for _, instr := range header.Instrs() {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+
+		incoming := make(map[*ssa.Block]ssa.Value)
+		for i := phi.NumIncoming() - 1; i >= 0; i-- {
+			if val, block := phi.GetIncoming(i); seen[block] {
+				incoming[block] = val
+				phi.RemoveIncoming(i)
+			}
+		}
+
+		if len(outside) == 1 {
+			phi.AddIncoming(incoming[outside[0]], preheader)
+			continue
+		}
+
+		merged := v.builder.CreatePhi(phi.Type(), "")
+		for _, pred := range outside {
+			merged.AddIncoming(incoming[pred], pred)
+		}
+		phi.AddIncoming(merged, preheader)
+	}
+
+	for _, use := range append([]*ssa.Use(nil), header.Uses()...) {
+		switch use.User().(type) {
+		case *ssa.Br, *ssa.CondBr, *ssa.Invoke:
+			if seen[use.User().Block()] {
+				use.Set(preheader)
+			}
+		}
+	}
+
+	v.builder.CreateBr(header)
+	return true
+}
+
+// Returns true if the value is not computed by an instruction in the loop.
+func isLoopInvariant(loop *analysis.Loop, val ssa.Value) bool {
+	instr, ok := val.(ssa.Instruction)
+	return !ok || !loop.Contains(instr.Block())
+}
+
+// Returns true if executing the instruction when it otherwise wouldn't be can't cause a trap.
+// Integer division traps if the divisor is zero, or if it is -1 and the dividend is the lowest signed value.
+func isSafeToSpeculate(instr ssa.Instruction) bool {
+	binOp, ok := instr.(*ssa.BinOp)
+	if !ok {
+		return true
+	}
+
+	switch binOp.BinOpType() {
+	case ssa.BinOpSDiv, ssa.BinOpUDiv, ssa.BinOpSRem, ssa.BinOpURem:
+		divisor, ok := ssa.GetOperands(binOp)[1].(*ssa.IntLiteral)
+		if !ok {
+			return false
+		}
+
+		val := divisor.LiteralValue().(uint64)
+		if val == 0 {
+			return false
+		}
+
+		width := divisor.Type().(*types.Int).Width()
+		signed := binOp.BinOpType() == ssa.BinOpSDiv || binOp.BinOpType() == ssa.BinOpSRem
+		return !signed || width > 64 || val != ^uint64(0)>>uint(64-width)
+
+	default:
+		return true
+	}
+}
+
+// Returns true if the pointer always points to memory which can be loaded from.
+func isDereferenceable(ptr ssa.Value) bool {
+	switch ptr := ptr.(type) {
+	case *ssa.Global:
+		return true
+	case *ssa.Alloc:
+		return ssa.GetOperands(ptr)[0] == nil // a single element
+	default:
+		return false
+	}
+}
+
+// Returns true if the block is executed on every iteration of the loop, as it dominates the latches
+// and every block which leaves the loop, including by returning.
+func (v *licm) executesEveryIteration(loop *analysis.Loop, block *ssa.Block) bool {
+	node := v.domTree.NodeForBlock(block)
+
+	for _, b := range loop.Blocks() {
+		succs := b.Successors()
+		leaves := len(succs) == 0
+		for _, succ := range succs {
+			if succ == loop.Header() || !loop.Contains(succ) {
+				leaves = true
+			}
+		}
+
+		if leaves && !v.domTree.NodeForBlock(b).DominatedBy(node, false) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// The instructions in a loop which access memory.
+type loopMemoryAccesses struct {
+	loads  []*ssa.Load
+	stores []*ssa.Store
+	calls  []ssa.Instruction // calls and invokes
+}
+
+func findLoopMemoryAccesses(loop *analysis.Loop) *loopMemoryAccesses {
+	accesses := &loopMemoryAccesses{}
+
+	for _, block := range loop.Blocks() {
+		for _, instr := range block.Instrs() {
+			switch instr := instr.(type) {
+			case *ssa.Load:
+				accesses.loads = append(accesses.loads, instr)
+			case *ssa.Store:
+				accesses.stores = append(accesses.stores, instr)
+			case *ssa.Call, *ssa.Invoke:
+				accesses.calls = append(accesses.calls, instr)
+			}
+		}
+	}
+
+	return accesses
+}
+
+// Moves the loop-invariant instructions of the loop to the end of the preheader. The blocks are visited in
+// dominator tree order, so the operands of an instruction are hoisted before it.
+func (v *licm) hoist(loop *analysis.Loop, preheader *ssa.Block) {
+	accesses := findLoopMemoryAccesses(loop)
+
+	var visit func(*analysis.DominatorTreeNode)
+	visit = func(node *analysis.DominatorTreeNode) {
+		for _, instr := range append([]ssa.Instruction(nil), node.Block().Instrs()...) {
+			if v.canHoist(loop, instr, accesses) {
+				ssa.MoveInstrBefore(instr, preheader.LastInstr())
+			}
+		}
+
+		// every block in the loop is dominated by another block in the loop, except the header
+		for _, child := range node.Children() {
+			if loop.Contains(child.Block()) {
+				visit(child)
+			}
+		}
+	}
+	visit(v.domTree.NodeForBlock(loop.Header()))
+}
+
+func (v *licm) canHoist(loop *analysis.Loop, instr ssa.Instruction, accesses *loopMemoryAccesses) bool {
+	switch instr.(type) {
+	case *ssa.BinOp, *ssa.ICmp, *ssa.Convert, *ssa.GEP, *ssa.Load:
+	default:
+		return false
+	}
+
+	for _, op := range ssa.GetOperands(instr) {
+		if !isLoopInvariant(loop, op) {
+			return false
+		}
+	}
+
+	if load, ok := instr.(*ssa.Load); ok {
+		return v.canHoistLoad(loop, load, accesses)
+	}
+	return isSafeToSpeculate(instr)
+}
+
+// Returns true if nothing in the loop may write to the memory the load reads, and the load can't trap
+// if it is executed when it otherwise wouldn't be.
+func (v *licm) canHoistLoad(loop *analysis.Loop, load *ssa.Load, accesses *loopMemoryAccesses) bool {
+	if v.aa == nil || load.IsVolatile() {
+		return false
+	}
+
+	ptr := ssa.GetOperands(load)[0]
+	if !isDereferenceable(ptr) && !v.executesEveryIteration(loop, load.Block()) {
+		return false
+	}
+
+	size := accessSize(v.layout, ptr)
+	for _, store := range accesses.stores {
+		storePtr := ssa.GetOperands(store)[0]
+		if v.aa.Alias(ptr, size, storePtr, accessSize(v.layout, storePtr)) != analysis.NoAlias {
+			return false
+		}
+	}
+	for _, call := range accesses.calls {
+		if v.aa.ModRef(call, ptr, size)&analysis.Mod != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Moves the stores which can be sunk from the loop into the blocks the loop exits to. This is only done if the
+// loop can't be left other than by branching to an exit block, and only the loop branches to the exit blocks, so
+// the stored value of the last iteration is available in all of them.
+func (v *licm) sinkStores(loop *analysis.Loop) {
+	exits := loop.ExitBlocks()
+	if len(exits) == 0 {
+		return
+	}
+
+	for _, exit := range exits {
+		for _, pred := range exit.Predecessors() {
+			if !loop.Contains(pred) {
+				return
+			}
+		}
+		if _, ok := firstNonPhi(exit).(*ssa.LandingPad); ok {
+			return
+		}
+	}
+
+	for _, block := range loop.Blocks() {
+		if len(block.Successors()) == 0 {
+			return
+		}
+	}
+
+	accesses := findLoopMemoryAccesses(loop)
+	for _, store := range accesses.stores {
+		if !v.canSinkStore(loop, store, accesses) {
+			continue
+		}
+
+		ops := ssa.GetOperands(store)
+		for _, exit := range exits {
+			v.builder.SetInsertBeforeInstr(firstNonPhi(exit))
+			v.builder.CreateStore(ops[0], ops[1]).SetAlign(store.Align())
+		}
+		ssa.EraseInstr(store)
+	}
+}
+
+// Returns true if the store is executed on every iteration to a loop-invariant pointer, and nothing else
+// in the loop may access the memory it writes, so only the store of the last iteration is ever observed.
+func (v *licm) canSinkStore(loop *analysis.Loop, store *ssa.Store, accesses *loopMemoryAccesses) bool {
+	ptr := ssa.GetOperands(store)[0]
+	if store.IsVolatile() || !isLoopInvariant(loop, ptr) || !v.executesEveryIteration(loop, store.Block()) {
+		return false
+	}
+
+	size := accessSize(v.layout, ptr)
+	for _, other := range accesses.stores {
+		if other == store || other.Block() == nil {
+			continue // already sunk
+		}
+
+		otherPtr := ssa.GetOperands(other)[0]
+		if v.aa.Alias(ptr, size, otherPtr, accessSize(v.layout, otherPtr)) != analysis.NoAlias {
+			return false
+		}
+	}
+	for _, load := range accesses.loads {
+		loadPtr := ssa.GetOperands(load)[0]
+		if v.aa.Alias(ptr, size, loadPtr, accessSize(v.layout, loadPtr)) != analysis.NoAlias {
+			return false
+		}
+	}
+	for _, call := range accesses.calls {
+		if v.aa.ModRef(call, ptr, size) != analysis.NoModRef {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,285 @@
+package ssaopt
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+	"github.com/MovingtoMars/nnvm/types"
+)
+
+// Mem2RegPass promotes allocs which are only loaded from and stored to into SSA values,
+// inserting phis where the stored values merge.
+type Mem2RegPass struct {
+}
+
+func NewMem2RegPass() *Mem2RegPass {
+	return &Mem2RegPass{}
+}
+
+func (_ Mem2RegPass) String() string {
+	return "mem2reg"
+}
+
+func (v Mem2RegPass) Run(mod *ssa.Module) {
+	v.RunWithAnalyses(mod, analysis.NewAnalysisManager())
+}
+
+func (v Mem2RegPass) RunWithAnalyses(mod *ssa.Module, am *analysis.AnalysisManager) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newMem2Reg(fn, am).run()
+		}
+	}
+}
+
+// Only instructions are added and removed, so the control flow is unchanged.
+func (_ Mem2RegPass) Preserved() []analysis.AnalysisID {
+	return []analysis.AnalysisID{
+		analysis.CFGAnalysis,
+		analysis.DominatorTreeAnalysis,
+		analysis.PostDominatorTreeAnalysis,
+		analysis.LoopInfoAnalysis,
+		analysis.CallGraphAnalysis,
+	}
+}
+
+type mem2reg struct {
+	fn      *ssa.Function
+	am      *analysis.AnalysisManager
+	domTree *analysis.DominatorTree
+
+	allocs    []*ssa.Alloc
+	isPromote map[*ssa.Alloc]bool
+
+	// the phis inserted at the start of each block, and the alloc each one is for
+	blockPhis map[*ssa.Block][]*ssa.Phi
+	phiAlloc  map[*ssa.Phi]*ssa.Alloc
+
+	zeroPointers map[string]ssa.Value // type string -> inttoptr of 0, created in the entry block on demand
+	builder      *ssa.Builder
+}
+
+func newMem2Reg(fn *ssa.Function, am *analysis.AnalysisManager) *mem2reg {
+	return &mem2reg{
+		fn:           fn,
+		am:           am,
+		isPromote:    make(map[*ssa.Alloc]bool),
+		blockPhis:    make(map[*ssa.Block][]*ssa.Phi),
+		phiAlloc:     make(map[*ssa.Phi]*ssa.Alloc),
+		zeroPointers: make(map[string]ssa.Value),
+		builder:      ssa.NewBuilder(),
+	}
+}
+
+func (v *mem2reg) run() {
+	for _, block := range v.fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			if alloc, ok := instr.(*ssa.Alloc); ok && isPromotable(alloc) {
+				v.allocs = append(v.allocs, alloc)
+				v.isPromote[alloc] = true
+			}
+		}
+	}
+
+	if len(v.allocs) == 0 {
+		return
+	}
+
+	v.domTree = v.am.DominatorTree(v.fn)
+	entry := v.domTree.NodeForBlock(v.fn.EntryBlock())
+
+	for _, alloc := range v.allocs {
+		v.insertPhis(alloc)
+	}
+
+	v.rename(entry, make(map[*ssa.Alloc]ssa.Value))
+
+	// loads and stores in unreachable blocks are never visited by rename
+	for _, block := range v.fn.Blocks() {
+		if v.domTree.NodeForBlock(block).ImmediateDominator() == nil && block != entry.Block() {
+			v.renameBlock(block, make(map[*ssa.Alloc]ssa.Value))
+		}
+	}
+
+	v.removeDeadPhis()
+
+	for _, alloc := range v.allocs {
+		ssa.EraseInstr(alloc)
+	}
+}
+
+// An alloc can be promoted if it holds a single scalar, and is only used as the location of
+// non-volatile loads and stores.
+func isPromotable(alloc *ssa.Alloc) bool {
+	if alloc.IsDynamic() || ssa.GetOperands(alloc)[0] != nil {
+		return false
+	}
+
+	switch alloc.Type().(*types.Pointer).Element().(type) {
+	case *types.Int, *types.Float, *types.Pointer:
+	default:
+		return false
+	}
+
+	for _, use := range alloc.Uses() {
+		switch user := use.User().(type) {
+		case *ssa.Load:
+			if user.IsVolatile() {
+				return false
+			}
+		case *ssa.Store:
+			if user.IsVolatile() || use.OperandIndex() != 0 {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+
+	return true
+}
+
+// Inserts a phi for the alloc at the start of every block in the iterated dominance frontier of the
+// blocks which store to it.
+func (v *mem2reg) insertPhis(alloc *ssa.Alloc) {
+	var defBlocks []*ssa.Block
+	for _, use := range alloc.Uses() {
+		if store, ok := use.User().(*ssa.Store); ok {
+			defBlocks = append(defBlocks, store.Block())
+		}
+	}
+
+	for _, block := range v.domTree.IteratedDominanceFrontier(defBlocks) {
+		v.builder.SetInsertBeforeInstr(block.FirstInstr())
+		phi := v.builder.CreatePhi(alloc.Type().(*types.Pointer).Element(), "")
+		v.blockPhis[block] = append(v.blockPhis[block], phi)
+		v.phiAlloc[phi] = alloc
+	}
+}
+
+// Walks the dominator tree, replacing loads with the value of the alloc at that point.
+// values holds the current value of each alloc which has been stored to.
+func (v *mem2reg) rename(node *analysis.DominatorTreeNode, values map[*ssa.Alloc]ssa.Value) {
+	v.renameBlock(node.Block(), values)
+
+	for _, child := range node.Children() {
+		childValues := make(map[*ssa.Alloc]ssa.Value, len(values))
+		for alloc, value := range values {
+			childValues[alloc] = value
+		}
+		v.rename(child, childValues)
+	}
+}
+
+func (v *mem2reg) renameBlock(block *ssa.Block, values map[*ssa.Alloc]ssa.Value) {
+	for _, phi := range v.blockPhis[block] {
+		values[v.phiAlloc[phi]] = phi
+	}
+
+	for _, instr := range append([]ssa.Instruction(nil), block.Instrs()...) {
+		switch instr := instr.(type) {
+		case *ssa.Alloc:
+			if v.isPromote[instr] {
+				delete(values, instr) // the memory is uninitialised again
+			}
+
+		case *ssa.Load:
+			ops := ssa.GetOperands(instr)
+			if alloc, ok := ops[0].(*ssa.Alloc); ok && v.isPromote[alloc] {
+				ssa.ReplaceAllValueReferences(instr, v.value(alloc, values))
+				ssa.EraseInstr(instr)
+			}
+
+		case *ssa.Store:
+			ops := ssa.GetOperands(instr)
+			if alloc, ok := ops[0].(*ssa.Alloc); ok && v.isPromote[alloc] {
+				values[alloc] = ops[1]
+				ssa.EraseInstr(instr)
+			}
+		}
+	}
+
+	seen := make(map[*ssa.Block]bool)
+	for _, succ := range block.Successors() {
+		if seen[succ] {
+			continue
+		}
+		seen[succ] = true
+
+		for _, phi := range v.blockPhis[succ] {
+			phi.AddIncoming(v.value(v.phiAlloc[phi], values), block)
+		}
+	}
+}
+
+// Returns the current value of the alloc, or a zero value if it is uninitialised.
+func (v *mem2reg) value(alloc *ssa.Alloc, values map[*ssa.Alloc]ssa.Value) ssa.Value {
+	if value, ok := values[alloc]; ok {
+		return value
+	}
+
+	switch typ := alloc.Type().(*types.Pointer).Element().(type) {
+	case *types.Int:
+		return ssa.NewIntLiteral(0, typ)
+
+	case *types.Float:
+		if typ.Type() == types.Float32 {
+			return ssa.NewFloat32Literal(0)
+		}
+		return ssa.NewFloat64Literal(0)
+
+	case *types.Pointer:
+		if zero, ok := v.zeroPointers[typ.String()]; ok {
+			return zero
+		}
+
+		v.builder.SetInsertBeforeInstr(v.fn.EntryBlock().FirstInstr())
+		zero := v.builder.CreateConvert(ssa.NewIntLiteral(0, types.NewInt(64)), typ, ssa.ConvertIntToPtr, "")
+		v.zeroPointers[typ.String()] = zero
+		return zero
+
+	default:
+		panic("unim")
+	}
+}
+
+// Removes the inserted phis which are only used by themselves or other dead phis.
+func (v *mem2reg) removeDeadPhis() {
+	live := make(map[*ssa.Phi]bool)
+	var work []*ssa.Phi
+
+	for phi := range v.phiAlloc {
+		for _, ref := range phi.References() {
+			if refPhi, ok := ref.(*ssa.Phi); !ok || v.phiAlloc[refPhi] == nil {
+				live[phi] = true
+				work = append(work, phi)
+				break
+			}
+		}
+	}
+
+	for len(work) > 0 {
+		phi := work[len(work)-1]
+		work = work[:len(work)-1]
+
+		for _, op := range ssa.GetOperands(phi) {
+			if opPhi, ok := op.(*ssa.Phi); ok && v.phiAlloc[opPhi] != nil && !live[opPhi] {
+				live[opPhi] = true
+				work = append(work, opPhi)
+			}
+		}
+	}
+
+	for phi := range v.phiAlloc {
+		if !live[phi] {
+			for phi.NumIncoming() > 0 {
+				phi.RemoveIncoming(0)
+			}
+		}
+	}
+
+	for phi := range v.phiAlloc {
+		if !live[phi] {
+			ssa.EraseInstr(phi)
+		}
+	}
+}
@@ -0,0 +1,286 @@
+package ssaopt
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
+)
+
+// SCCPPass performs sparse conditional constant propagation. Values are assumed to be undefined until shown
+// otherwise, and blocks are assumed to never execute until an executable edge to them is found, so constants
+// are found even when they flow around loops through phis, or along only the edges which can be taken.
+// Values found to be constant are replaced with literals, and branches which are never taken are removed
+// along with the blocks only they lead to.
+type SCCPPass struct {
+}
+
+func NewSCCPPass() *SCCPPass {
+	return &SCCPPass{}
+}
+
+func (_ SCCPPass) String() string {
+	return "sccp"
+}
+
+func (v SCCPPass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newSCCP(fn).run()
+		}
+	}
+}
+
+type sccpLatticeKind int
+
+const (
+	sccpUndefined   sccpLatticeKind = iota // no value has been seen yet
+	sccpConstant                           // always the same literal
+	sccpOverdefined                        // may have more than one value
+)
+
+type sccpLatticeValue struct {
+	kind sccpLatticeKind
+	lit  ssa.Literal // if constant
+}
+
+var sccpOverdefinedValue = sccpLatticeValue{kind: sccpOverdefined}
+
+func (v sccpLatticeValue) meet(w sccpLatticeValue) sccpLatticeValue {
+	switch {
+	case v.kind == sccpUndefined:
+		return w
+	case w.kind == sccpUndefined:
+		return v
+	case v.kind == sccpConstant && w.kind == sccpConstant && analysis.LiteralsEqual(v.lit, w.lit):
+		return v
+	default:
+		return sccpOverdefinedValue
+	}
+}
+
+type sccpEdge struct {
+	from, to *ssa.Block
+}
+
+type sccp struct {
+	fn *ssa.Function
+
+	values           map[ssa.Instruction]sccpLatticeValue
+	executableBlocks map[*ssa.Block]bool
+	executableEdges  map[sccpEdge]bool
+
+	edgeWorklist  []sccpEdge
+	instrWorklist []ssa.Instruction // instructions whose value changed, so their users must be visited again
+}
+
+func newSCCP(fn *ssa.Function) *sccp {
+	return &sccp{
+		fn:               fn,
+		values:           make(map[ssa.Instruction]sccpLatticeValue),
+		executableBlocks: make(map[*ssa.Block]bool),
+		executableEdges:  make(map[sccpEdge]bool),
+	}
+}
+
+func (v *sccp) run() {
+	entry := v.fn.EntryBlock()
+	v.executableBlocks[entry] = true
+	v.visitBlock(entry)
+
+	for len(v.edgeWorklist) > 0 || len(v.instrWorklist) > 0 {
+		for len(v.instrWorklist) > 0 {
+			instr := v.instrWorklist[len(v.instrWorklist)-1]
+			v.instrWorklist = v.instrWorklist[:len(v.instrWorklist)-1]
+
+			for _, use := range instr.(ssa.Value).Uses() {
+				if user := use.User(); v.executableBlocks[user.Block()] {
+					v.visit(user)
+				}
+			}
+		}
+
+		for len(v.edgeWorklist) > 0 {
+			edge := v.edgeWorklist[len(v.edgeWorklist)-1]
+			v.edgeWorklist = v.edgeWorklist[:len(v.edgeWorklist)-1]
+
+			if !v.executableBlocks[edge.to] {
+				v.executableBlocks[edge.to] = true
+				v.visitBlock(edge.to)
+			} else {
+				// only the phis depend on which edges are executable
+				for _, instr := range edge.to.Instrs() {
+					if _, ok := instr.(*ssa.Phi); !ok {
+						break
+					}
+					v.visit(instr)
+				}
+			}
+		}
+	}
+
+	v.rewrite()
+}
+
+func (v *sccp) visitBlock(block *ssa.Block) {
+	for _, instr := range block.Instrs() {
+		v.visit(instr)
+	}
+}
+
+func (v *sccp) markEdgeExecutable(from, to *ssa.Block) {
+	edge := sccpEdge{from, to}
+	if !v.executableEdges[edge] {
+		v.executableEdges[edge] = true
+		v.edgeWorklist = append(v.edgeWorklist, edge)
+	}
+}
+
+// Returns the lattice value of an operand.
+func (v *sccp) latticeValue(val ssa.Value) sccpLatticeValue {
+	switch val := val.(type) {
+	case *ssa.IntLiteral, *ssa.FloatLiteral:
+		return sccpLatticeValue{kind: sccpConstant, lit: val.(ssa.Literal)}
+	case ssa.Instruction:
+		return v.values[val]
+	default:
+		return sccpOverdefinedValue
+	}
+}
+
+// Lowers the lattice value of the instruction to res, which must not be higher.
+func (v *sccp) setValue(instr ssa.Instruction, res sccpLatticeValue) {
+	old := v.values[instr]
+	if old.kind == res.kind && (res.kind != sccpConstant || analysis.LiteralsEqual(old.lit, res.lit)) {
+		return
+	}
+
+	v.values[instr] = res
+	v.instrWorklist = append(v.instrWorklist, instr)
+}
+
+func (v *sccp) visit(instr ssa.Instruction) {
+	if v.values[instr].kind == sccpOverdefined {
+		return // can't change
+	}
+
+	ops := ssa.GetOperands(instr)
+
+	switch instr := instr.(type) {
+	case *ssa.Phi:
+		res := sccpLatticeValue{}
+		for i := 0; i < instr.NumIncoming(); i++ {
+			val, pred := instr.GetIncoming(i)
+			if v.executableEdges[sccpEdge{pred, instr.Block()}] {
+				res = res.meet(v.latticeValue(val))
+			}
+		}
+		v.setValue(instr, res)
+
+	case *ssa.BinOp, *ssa.ICmp, *ssa.Convert:
+		var lits []ssa.Literal
+		for _, op := range ops {
+			val := v.latticeValue(op)
+			switch val.kind {
+			case sccpUndefined:
+				return
+			case sccpOverdefined:
+				v.setValue(instr, sccpOverdefinedValue)
+				return
+			}
+			lits = append(lits, val.lit)
+		}
+
+		var lit ssa.Literal
+		switch instr := instr.(type) {
+		case *ssa.BinOp:
+			lit = analysis.FoldBinOp(instr.BinOpType(), lits[0], lits[1])
+		case *ssa.ICmp:
+			lit = analysis.FoldICmp(instr.Predicate(), lits[0], lits[1])
+		case *ssa.Convert:
+			lit = analysis.FoldConvert(instr.ConvertType(), lits[0], instr.Type())
+		}
+
+		if lit == nil {
+			v.setValue(instr, sccpOverdefinedValue)
+		} else {
+			v.setValue(instr, sccpLatticeValue{kind: sccpConstant, lit: lit})
+		}
+
+	case *ssa.Br:
+		v.markEdgeExecutable(instr.Block(), ops[0].(*ssa.Block))
+
+	case *ssa.CondBr:
+		trueTarget, falseTarget := ops[1].(*ssa.Block), ops[2].(*ssa.Block)
+
+		cond := v.latticeValue(ops[0])
+		switch cond.kind {
+		case sccpUndefined:
+			// wait until the condition is known
+		case sccpConstant:
+			if cond.lit.LiteralValue().(uint64) != 0 {
+				v.markEdgeExecutable(instr.Block(), trueTarget)
+			} else {
+				v.markEdgeExecutable(instr.Block(), falseTarget)
+			}
+		default:
+			v.markEdgeExecutable(instr.Block(), trueTarget)
+			v.markEdgeExecutable(instr.Block(), falseTarget)
+		}
+
+	case *ssa.Invoke:
+		v.setValue(instr, sccpOverdefinedValue)
+		v.markEdgeExecutable(instr.Block(), instr.NormalTarget())
+		v.markEdgeExecutable(instr.Block(), instr.UnwindTarget())
+
+	default:
+		if _, ok := instr.(ssa.Value); ok {
+			v.setValue(instr, sccpOverdefinedValue)
+		}
+	}
+}
+
+// Replaces the constant instructions with literals, and the CondBrs with only one executable edge with Brs.
+// The blocks which were never executed are then unreachable, and are removed.
+func (v *sccp) rewrite() {
+	builder := ssa.NewBuilder()
+
+	for _, block := range v.fn.Blocks() {
+		if !v.executableBlocks[block] {
+			continue
+		}
+
+		for _, instr := range append([]ssa.Instruction(nil), block.Instrs()...) {
+			if val := v.values[instr]; val.kind == sccpConstant {
+				ssa.ReplaceAllValueReferences(instr.(ssa.Value), val.lit)
+				ssa.EraseInstr(instr)
+				continue
+			}
+
+			condBr, ok := instr.(*ssa.CondBr)
+			if !ok {
+				continue
+			}
+
+			ops := ssa.GetOperands(condBr)
+			trueTarget, falseTarget := ops[1].(*ssa.Block), ops[2].(*ssa.Block)
+			trueExecutable := v.executableEdges[sccpEdge{block, trueTarget}]
+			falseExecutable := v.executableEdges[sccpEdge{block, falseTarget}]
+
+			if trueTarget == falseTarget || trueExecutable == falseExecutable {
+				// both are taken, or the condition is undefined and neither is, so the branch is left alone
+				continue
+			}
+
+			target, other := trueTarget, falseTarget
+			if falseExecutable {
+				target, other = falseTarget, trueTarget
+			}
+
+			builder.SetInsertBeforeInstr(condBr)
+			builder.CreateBr(target)
+			ssa.EraseInstr(condBr)
+			removeIncomingFrom(other, block)
+		}
+	}
+
+	removeUnreachableBlocks(v.fn)
+}
@@ -0,0 +1,224 @@
+package ssaopt
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+// SimplifyCFGPass removes unreachable blocks, turns CondBrs with identical targets into Brs, folds phis with a
+// single incoming value, merges blocks into their sole predecessor when it always branches to them, and redirects
+// branches to blocks which only branch elsewhere. This is repeated until nothing more can be simplified.
+// The entry block is never removed or branched to.
+type SimplifyCFGPass struct {
+}
+
+func NewSimplifyCFGPass() *SimplifyCFGPass {
+	return &SimplifyCFGPass{}
+}
+
+func (_ SimplifyCFGPass) String() string {
+	return "simplify cfg"
+}
+
+func (v SimplifyCFGPass) Run(mod *ssa.Module) {
+	for _, fn := range mod.Functions() {
+		if !fn.IsPrototype() {
+			newSimplifyCFG(fn).run()
+		}
+	}
+}
+
+type simplifyCFG struct {
+	fn      *ssa.Function
+	builder *ssa.Builder
+}
+
+func newSimplifyCFG(fn *ssa.Function) *simplifyCFG {
+	return &simplifyCFG{
+		fn:      fn,
+		builder: ssa.NewBuilder(),
+	}
+}
+
+func (v *simplifyCFG) run() {
+	for changed := true; changed; {
+		changed = removeUnreachableBlocks(v.fn)
+
+		// blocks are erased as we go
+		blocks := append([]*ssa.Block(nil), v.fn.Blocks()...)
+		for _, block := range blocks {
+			if block.Function() == nil {
+				continue
+			}
+
+			if v.simplifyCondBr(block) {
+				changed = true
+			}
+			if foldSingleIncomingPhis(block) {
+				changed = true
+			}
+			if v.mergeIntoPredecessor(block) || v.threadForwardingBlock(block) {
+				changed = true
+			}
+		}
+	}
+}
+
+// Replaces a CondBr whose targets are the same block with a Br.
+func (v *simplifyCFG) simplifyCondBr(block *ssa.Block) bool {
+	condBr, ok := block.LastInstr().(*ssa.CondBr)
+	if !ok {
+		return false
+	}
+
+	ops := ssa.GetOperands(condBr)
+	target := ops[1].(*ssa.Block)
+	if target != ops[2].(*ssa.Block) {
+		return false
+	}
+
+	// the phis of the target already only have one incoming value from the block
+	v.builder.SetInsertBeforeInstr(condBr)
+	v.builder.CreateBr(target)
+	ssa.EraseInstr(condBr)
+	return true
+}
+
+// Replaces phis with a single incoming value with that value.
+func foldSingleIncomingPhis(block *ssa.Block) bool {
+	changed := false
+
+	for _, instr := range append([]ssa.Instruction(nil), block.Instrs()...) {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+
+		if phi.NumIncoming() != 1 {
+			continue
+		}
+
+		val, _ := phi.GetIncoming(0)
+		if val == phi {
+			continue // only possible in unreachable blocks
+		}
+
+		ssa.ReplaceAllValueReferences(phi, val)
+		ssa.EraseInstr(phi)
+		changed = true
+	}
+
+	return changed
+}
+
+// Returns the only predecessor of the block, or nil if it has none or more than one.
+// A predecessor which branches to the block more than once counts more than once.
+func singlePredecessor(block *ssa.Block) *ssa.Block {
+	preds := block.Predecessors()
+	if len(preds) != 1 {
+		return nil
+	}
+	return preds[0]
+}
+
+// Moves the instructions of the block to the end of its predecessor, if the predecessor has no other
+// successors and the block has no other predecessors, and erases the block.
+func (v *simplifyCFG) mergeIntoPredecessor(block *ssa.Block) bool {
+	if block.IsEntry() {
+		return false
+	}
+
+	pred := singlePredecessor(block)
+	if pred == nil || pred == block {
+		return false
+	}
+
+	br, ok := pred.LastInstr().(*ssa.Br)
+	if !ok {
+		return false
+	}
+
+	// phis which can't be folded have no incoming values, and are only in blocks which will be removed
+	if _, ok := block.FirstInstr().(*ssa.Phi); ok {
+		return false
+	}
+
+	ssa.EraseInstr(br)
+	for _, instr := range append([]ssa.Instruction(nil), block.Instrs()...) {
+		ssa.MoveInstrToBlockEnd(instr, pred)
+	}
+
+	// the phis of the successors now have incoming values from the predecessor
+	ssa.ReplaceAllValueReferences(block, pred)
+	ssa.EraseBlocks(block)
+	return true
+}
+
+// Redirects the branches to a block containing only a Br to the Br's target, and erases the block.
+// The block is kept if one of its predecessors already branches to the target and the target has phis,
+// as the phis could then need two different incoming values from the predecessor.
+func (v *simplifyCFG) threadForwardingBlock(block *ssa.Block) bool {
+	if block.IsEntry() || block.NumInstrs() != 1 {
+		return false
+	}
+
+	br, ok := block.LastInstr().(*ssa.Br)
+	if !ok {
+		return false
+	}
+
+	target := ssa.GetOperands(br)[0].(*ssa.Block)
+	if target == block {
+		return false
+	}
+
+	_, targetHasPhis := target.FirstInstr().(*ssa.Phi)
+	targetPreds := make(map[*ssa.Block]bool)
+	for _, pred := range target.Predecessors() {
+		targetPreds[pred] = true
+	}
+
+	var preds []*ssa.Block
+	seen := make(map[*ssa.Block]bool)
+	for _, pred := range block.Predecessors() {
+		if _, ok := pred.LastInstr().(*ssa.Invoke); ok {
+			// the normal target of an invoke must dominate the uses of its value
+			return false
+		}
+		if targetHasPhis && targetPreds[pred] {
+			return false
+		}
+
+		if !seen[pred] {
+			seen[pred] = true
+			preds = append(preds, pred)
+		}
+	}
+
+	if len(preds) == 0 {
+		return false
+	}
+
+	for _, use := range append([]*ssa.Use(nil), block.Uses()...) {
+		if _, ok := use.User().(*ssa.Phi); !ok {
+			use.Set(target)
+		}
+	}
+
+	for _, instr := range target.Instrs() {
+		phi, ok := instr.(*ssa.Phi)
+		if !ok {
+			break
+		}
+
+		for i := 0; i < phi.NumIncoming(); i++ {
+			if val, incomingBlock := phi.GetIncoming(i); incomingBlock == block {
+				phi.RemoveIncoming(i)
+				for _, pred := range preds {
+					phi.AddIncoming(val, pred)
+				}
+				break
+			}
+		}
+	}
+
+	ssa.EraseBlocks(block)
+	return true
+}
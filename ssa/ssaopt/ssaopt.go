@@ -2,6 +2,7 @@ package ssaopt
 
 import (
 	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
 	"github.com/MovingtoMars/nnvm/ssa/validate"
 )
 
@@ -10,6 +11,20 @@ type Pass interface {
 	String() string
 }
 
+// AnalysisPass can optionally be implemented by a Pass to use the analyses cached by a PassList instead of
+// computing its own. The PassList calls RunWithAnalyses instead of Run.
+type AnalysisPass interface {
+	Pass
+	RunWithAnalyses(*ssa.Module, *analysis.AnalysisManager)
+}
+
+// PreservingPass can optionally be implemented by a Pass to declare which analyses are still valid after it runs.
+// The results of all other analyses are discarded. Passes which don't implement it preserve nothing.
+type PreservingPass interface {
+	Pass
+	Preserved() []analysis.AnalysisID
+}
+
 type PassList struct {
 	passes []Pass
 }
@@ -23,13 +38,26 @@ func NewPassList(passes ...Pass) *PassList {
 // Will panic if the module is invalid!
 // Can be called on multiple modules in parallel.
 func (v PassList) Run(mod *ssa.Module) {
-	if err := validate.Validate(mod); err != nil {
+	am := analysis.NewAnalysisManager()
+
+	if err := validate.ValidateWithAnalyses(mod, am); err != nil {
 		panic(err)
 	}
 
 	for _, pass := range v.passes {
-		pass.Run(mod)
+		if analysisPass, ok := pass.(AnalysisPass); ok {
+			analysisPass.RunWithAnalyses(mod, am)
+		} else {
+			pass.Run(mod)
+		}
+
+		var preserved []analysis.AnalysisID
+		if preservingPass, ok := pass.(PreservingPass); ok {
+			preserved = preservingPass.Preserved()
+		}
+		am.Invalidate(mod, preserved...)
 
+		// validated with fresh analyses, so a pass which wrongly claims to preserve one can't hide its own errors
 		if err := validate.Validate(mod); err != nil {
 			panic("Pass `" + pass.String() + "` caused validation error: " + err.Error())
 		}
@@ -1,10 +1,15 @@
 package ssa
 
+import "fmt"
+
 type Store struct {
 	BlockHandler
+	OperandHandler
 
 	location Value
 	value    Value
+	align    int // 0 for the natural alignment of the type
+	volatile bool
 }
 
 func newStore(location, value Value) *Store {
@@ -19,7 +24,42 @@ func (v *Store) operands() []*Value {
 }
 
 func (v Store) String() string {
-	return "store " + ValueString(v.location) + ", " + ValueString(v.value)
+	return v.format(ValueIdentifier)
+}
+
+func (v Store) format(ident func(Value) string) string {
+	str := "store "
+
+	if v.volatile {
+		str += "volatile "
+	}
+
+	str += valueString(ident, v.location) + ", " + valueString(ident, v.value)
+
+	if v.align != 0 {
+		str += fmt.Sprintf(", align %d", v.align)
+	}
+
+	return str
+}
+
+// Align returns the alignment of the location in bytes, or 0 for the natural alignment of the type.
+func (v Store) Align() int {
+	return v.align
+}
+
+// SetAlign sets the alignment of the location in bytes. It must be 0 or a power of 2.
+func (v *Store) SetAlign(align int) {
+	v.align = align
+}
+
+// Volatile stores must not be removed, duplicated, or reordered with other volatile accesses.
+func (v Store) IsVolatile() bool {
+	return v.volatile
+}
+
+func (v *Store) SetVolatile(volatile bool) {
+	v.volatile = volatile
 }
 
 func (_ Store) IsTerminating() bool {
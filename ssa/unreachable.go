@@ -5,13 +5,18 @@ package ssa
 // Implementation is undefined.
 type Unreachable struct {
 	BlockHandler
+	OperandHandler
 }
 
 func newUnreachable() *Unreachable {
 	return &Unreachable{}
 }
 
-func (_ Unreachable) String() string {
+func (v Unreachable) String() string {
+	return v.format(ValueIdentifier)
+}
+
+func (_ Unreachable) format(ident func(Value) string) string {
 	return "unreachable"
 }
 
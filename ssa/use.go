@@ -0,0 +1,83 @@
+package ssa
+
+// Use represents a single operand of an instruction.
+// An instruction which references a value more than once has a separate Use for each operand.
+type Use struct {
+	user      Instruction
+	index     int // operand index in the user
+	value     Value
+	listIndex int // index in the use list of the value
+}
+
+func (v Use) User() Instruction {
+	return v.user
+}
+
+func (v Use) OperandIndex() int {
+	return v.index
+}
+
+func (v Use) Value() Value {
+	return v.value
+}
+
+// Set replaces the operand of the user with the specified value.
+// Only this operand is changed, even if the old value is referenced elsewhere in the user.
+func (v *Use) Set(newValue Value) {
+	setOperand(v.user, v.index, newValue)
+}
+
+// setOperand sets the operand at the specified index of the instruction, updating use lists.
+// newValue may be nil.
+func setOperand(instr Instruction, index int, newValue Value) {
+	uses := *instr.useList()
+
+	if old := uses[index]; old != nil {
+		old.value.removeUse(old)
+		uses[index] = nil
+	}
+
+	*instr.operands()[index] = newValue
+
+	if newValue != nil {
+		uses[index] = newUse(instr, index, newValue)
+	}
+}
+
+func newUse(user Instruction, index int, value Value) *Use {
+	use := &Use{
+		user:  user,
+		index: index,
+		value: value,
+	}
+	value.addUse(use)
+	return use
+}
+
+// addOperandUses creates a use for every non-nil operand of the instruction.
+func addOperandUses(instr Instruction) {
+	ops := instr.operands()
+	uses := make([]*Use, len(ops))
+
+	for i, op := range ops {
+		if *op != nil {
+			uses[i] = newUse(instr, i, *op)
+		}
+	}
+
+	*instr.useList() = uses
+}
+
+// removeOperandUses removes every use held by the instruction from the use lists of its operands.
+// The operands themselves are not changed.
+func removeOperandUses(instr Instruction) {
+	uses := instr.useList()
+
+	for _, use := range *uses {
+		if use != nil {
+			use.value.removeUse(use)
+		}
+	}
+
+	*uses = nil
+}
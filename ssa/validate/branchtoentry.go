@@ -31,6 +31,11 @@ func checkBranchToEntry(mod *ssa.Module) error {
 					}
 				}
 
+			case *ssa.Invoke:
+				if i.NormalTarget().IsEntry() || i.UnwindTarget().IsEntry() {
+					return makeError(i)
+				}
+
 			}
 		}
 	}
@@ -8,11 +8,11 @@ import (
 	"github.com/MovingtoMars/nnvm/types"
 )
 
-func checkInstrs(mod *ssa.Module) error {
+func checkInstrs(mod *ssa.Module, am *analysis.AnalysisManager) error {
 	for _, fn := range mod.Functions() {
 		var blockDomTree *analysis.DominatorTree
 		if !fn.IsPrototype() {
-			blockDomTree = analysis.NewBlockDominatorTree(analysis.NewBlockCFG(fn))
+			blockDomTree = am.DominatorTree(fn)
 		}
 
 		for _, block := range fn.Blocks() {
@@ -32,7 +32,14 @@ func checkInstr(instr ssa.Instruction, blockDomTree *analysis.DominatorTree) err
 
 	if _, ok := instr.(*ssa.Phi); !ok {
 		for _, op := range ssa.GetOperands(instr) {
-			if opInstr, ok := op.(ssa.Instruction); ok {
+			if invoke, ok := op.(*ssa.Invoke); ok {
+				if !thisBlockNode.DominatedBy(blockDomTree.NodeForBlock(invoke.NormalTarget()), false) {
+					return &InstrError{
+						Instr:   instr,
+						Message: "Instruction is not dominated by the normal target of operand `" + ssa.ValueString(op) + "`",
+					}
+				}
+			} else if opInstr, ok := op.(ssa.Instruction); ok {
 				opInstrBlock := opInstr.Block()
 				if thisBlockNode.DominatedBy(blockDomTree.NodeForBlock(opInstrBlock), true) {
 					continue
@@ -56,7 +63,13 @@ func checkInstr(instr ssa.Instruction, blockDomTree *analysis.DominatorTree) err
 	case *ssa.Load:
 		return checkLoad(i)
 	case *ssa.Call:
-		return checkCall(i)
+		return checkCall(i, ssa.GetOperands(i))
+	case *ssa.Invoke:
+		return checkInvoke(i)
+	case *ssa.LandingPad:
+		return checkLandingPad(i)
+	case *ssa.ExtractValue:
+		return checkExtractValue(i)
 	case *ssa.Alloc:
 		return checkAlloc(i)
 	case *ssa.Store:
@@ -350,16 +363,100 @@ func checkStore(instr *ssa.Store) error {
 		return err
 	}
 
-	return nil
+	return errIfInvalidAlign(instr, instr.Align())
 }
 
 func checkAlloc(instr *ssa.Alloc) error {
-	return errIfNonFirstClassType(instr.Type(), instr)
+	if err := errIfNonFirstClassType(instr.Type(), instr); err != nil {
+		return err
+	}
+
+	if count := ssa.GetOperands(instr)[0]; count != nil {
+		if err := errIfNotIntType(instr, count.Type()); err != nil {
+			return err
+		}
+	}
+
+	return errIfInvalidAlign(instr, instr.Align())
+}
+
+func errIfInvalidAlign(i ssa.Instruction, align int) error {
+	if align < 0 || align&(align-1) != 0 {
+		return &InstrError{
+			Instr:   i,
+			Message: fmt.Sprintf("Alignment %d is not a power of 2", align),
+		}
+	}
+	return nil
 }
 
-func checkCall(instr *ssa.Call) error {
+func checkInvoke(instr *ssa.Invoke) error {
 	ops := ssa.GetOperands(instr)
 
+	for _, target := range ops[len(ops)-2:] {
+		if err := errIfNotLabelType(instr, target.Type()); err != nil {
+			return err
+		}
+	}
+
+	if instr.NormalTarget() == instr.UnwindTarget() {
+		return &InstrError{
+			Instr:   instr,
+			Message: "Normal and unwind targets must differ",
+		}
+	}
+
+	return checkCall(instr, ops[:len(ops)-2])
+}
+
+func checkLandingPad(instr *ssa.LandingPad) error {
+	if !instr.IsCleanup() && !instr.IsCatchAll() && instr.NumCatches() == 0 {
+		return &InstrError{
+			Instr:   instr,
+			Message: "Landing pad has no clauses and is not a cleanup",
+		}
+	}
+
+	for _, catch := range ssa.GetOperands(instr) {
+		if _, ok := catch.(*ssa.Global); !ok {
+			return &InstrError{
+				Instr:   instr,
+				Message: "Catch clause `" + ssa.ValueString(catch) + "` is not a global",
+			}
+		}
+	}
+
+	return nil
+}
+
+func checkExtractValue(instr *ssa.ExtractValue) error {
+	op := ssa.GetOperands(instr)[0]
+
+	length := 0
+	switch typ := op.Type().(type) {
+	case *types.Struct:
+		length = len(typ.Fields())
+	case *types.Array:
+		length = typ.Length()
+	default:
+		return &InstrError{
+			Instr:   instr,
+			Message: "Expected struct or array type, found `" + op.Type().String() + "`",
+		}
+	}
+
+	if instr.Index() < 0 || instr.Index() >= length {
+		return &InstrError{
+			Instr:   instr,
+			Message: fmt.Sprintf("Index %d out of range", instr.Index()),
+		}
+	}
+
+	return nil
+}
+
+// ops holds the callee followed by the arguments.
+func checkCall(instr ssa.Instruction, ops []ssa.Value) error {
 	sig, ok := ops[0].Type().(*types.Signature)
 	if !ok {
 		return &InstrError{
@@ -417,7 +514,7 @@ func checkLoad(instr *ssa.Load) error {
 		}
 	}
 
-	return nil
+	return errIfInvalidAlign(instr, instr.Align())
 }
 
 func checkBinOp(instr *ssa.BinOp) error {
@@ -0,0 +1,61 @@
+package validate
+
+import "github.com/MovingtoMars/nnvm/ssa"
+
+// Checks that landing pads are only entered by unwinding, and that every unwind target begins with one.
+func checkLandingPads(mod *ssa.Module) error {
+	for _, fn := range mod.Functions() {
+		for _, block := range fn.Blocks() {
+			var landingPad *ssa.LandingPad
+
+			for i, instr := range block.Instrs() {
+				if lp, ok := instr.(*ssa.LandingPad); ok {
+					if i > 0 {
+						if _, ok := block.InstrAtIndex(i - 1).(*ssa.Phi); !ok {
+							return &InstrError{
+								Instr:   instr,
+								Message: "Landing pad must be the first non-phi instruction in its block",
+							}
+						}
+					}
+
+					if fn.Personality() == nil {
+						return &InstrError{
+							Instr:   instr,
+							Message: "Landing pad in function without personality",
+						}
+					}
+
+					landingPad = lp
+				}
+			}
+
+			for _, use := range block.Uses() {
+				switch user := use.User().(type) {
+				case *ssa.Invoke:
+					if user.UnwindTarget() == block && landingPad == nil {
+						return &InstrError{
+							Instr:   user,
+							Message: "Unwind target `" + block.Name() + "` does not begin with a landing pad",
+						}
+					} else if user.NormalTarget() == block && landingPad != nil {
+						return &InstrError{
+							Instr:   user,
+							Message: "Normal target `" + block.Name() + "` begins with a landing pad",
+						}
+					}
+
+				case *ssa.Br, *ssa.CondBr:
+					if landingPad != nil {
+						return &InstrError{
+							Instr:   user,
+							Message: "Branch to landing pad block `" + block.Name() + "`",
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
@@ -38,7 +38,14 @@ func checkPhi(instr *ssa.Phi, dom *analysis.DominatorTree) error {
 
 		incomingBlockMap[block] = true
 
-		if valInstr, ok := val.(ssa.Instruction); ok {
+		if invoke, ok := val.(*ssa.Invoke); ok {
+			if !dom.NodeForBlock(block).DominatedBy(dom.NodeForBlock(invoke.NormalTarget()), false) {
+				return &InstrError{
+					Instr:   instr,
+					Message: fmt.Sprintf("Normal target of `%s` must dominate block `%s`", val.Name(), block.Name()),
+				}
+			}
+		} else if valInstr, ok := val.(ssa.Instruction); ok {
 			// for an incoming value to be valid, the incoming block must be dominated by the block the value comes from
 			if !dom.NodeForBlock(block).DominatedBy(dom.NodeForBlock(valInstr.Block()), false) {
 				return &InstrError{
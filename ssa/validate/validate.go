@@ -6,25 +6,36 @@ import (
 	"github.com/MovingtoMars/nnvm/types"
 
 	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/ssa/analysis"
 )
 
 // TODO the check functions should be renamed to make it more clear what they do
 
-var moduleCheckFunctions = []func(*ssa.Module) error{
-	checkEmptyBlock,
-	checkIllegalTerminate,
-	checkBlockDoesNotTerminate,
-	checkBranchToEntry,
-	checkEntryPhi,
-	checkInstrs,
-	checkFunctionNames,
-	checkGlobals,
+func moduleCheckFunctions(am *analysis.AnalysisManager) []func(*ssa.Module) error {
+	return []func(*ssa.Module) error{
+		checkEmptyBlock,
+		checkIllegalTerminate,
+		checkBlockDoesNotTerminate,
+		checkBranchToEntry,
+		checkEntryPhi,
+		checkLandingPads,
+		func(mod *ssa.Module) error { return checkInstrs(mod, am) },
+		checkFunctionNames,
+		checkGlobals,
+	}
 }
 
 // Validate attempts to validate the passed module, returning an error if validation fails.
 // Note that if the module is modified between calling Validate and viewing the error, the error may be incorrect or nonsensical.
 func Validate(mod *ssa.Module) error {
-	for _, fn := range moduleCheckFunctions {
+	return ValidateWithAnalyses(mod, analysis.NewAnalysisManager())
+}
+
+// ValidateWithAnalyses is like Validate, but gets the analyses it needs from the manager, so they can be reused
+// by later passes and validations. The analyses are only computed once the module is known to be well-formed
+// enough to build them.
+func ValidateWithAnalyses(mod *ssa.Module, am *analysis.AnalysisManager) error {
+	for _, fn := range moduleCheckFunctions(am) {
 		if err := fn(mod); err != nil {
 			return err
 		}
@@ -80,21 +91,20 @@ func errIfVoidType(t types.Type, i ssa.Instruction) error {
 }
 
 func VisitInstrs(mod *ssa.Module, visitFn func(ssa.Instruction)) {
-	for _, fn := range mod.Functions() {
-		for _, block := range fn.Blocks() {
-			for _, instr := range block.Instrs() {
-				visitFn(instr)
-			}
-		}
-	}
+	ssa.Walk(ssa.DefaultVisitor{Default: visitFn}, mod, nil)
+}
+
+type blockVisitor struct {
+	ssa.DefaultVisitor
+	visitFn func(*ssa.Block)
+}
+
+func (v blockVisitor) VisitBlock(block *ssa.Block) {
+	v.visitFn(block)
 }
 
 func VisitBlocks(mod *ssa.Module, visitFn func(*ssa.Block)) {
-	for _, fn := range mod.Functions() {
-		for _, block := range fn.Blocks() {
-			visitFn(block)
-		}
-	}
+	ssa.Walk(blockVisitor{visitFn: visitFn}, mod, nil)
 }
 
 type FunctionError struct {
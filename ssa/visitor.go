@@ -0,0 +1,104 @@
+package ssa
+
+// Visitor has one method per instruction kind. VisitInstr is the default, and
+// is called for any instruction kind the visitor has no specific method for.
+type Visitor interface {
+	VisitAlloc(*Alloc)
+	VisitBinOp(*BinOp)
+	VisitBr(*Br)
+	VisitCall(*Call)
+	VisitCondBr(*CondBr)
+	VisitConvert(*Convert)
+	VisitExtractValue(*ExtractValue)
+	VisitGEP(*GEP)
+	VisitICmp(*ICmp)
+	VisitInvoke(*Invoke)
+	VisitLandingPad(*LandingPad)
+	VisitLoad(*Load)
+	VisitPhi(*Phi)
+	VisitRet(*Ret)
+	VisitStore(*Store)
+	VisitUnreachable(*Unreachable)
+
+	VisitInstr(Instruction)
+}
+
+// FunctionVisitor can optionally be implemented by a Visitor to be notified when Walk enters a function.
+type FunctionVisitor interface {
+	VisitFunction(*Function)
+}
+
+// BlockVisitor can optionally be implemented by a Visitor to be notified when Walk enters a block.
+type BlockVisitor interface {
+	VisitBlock(*Block)
+}
+
+// DefaultVisitor forwards every instruction kind to Default.
+// It is intended to be embedded in visitors that only handle a few instruction kinds.
+// If Default is nil, unhandled instructions are ignored.
+type DefaultVisitor struct {
+	Default func(Instruction)
+}
+
+func (v DefaultVisitor) VisitAlloc(i *Alloc)               { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitBinOp(i *BinOp)               { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitBr(i *Br)                     { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitCall(i *Call)                 { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitCondBr(i *CondBr)             { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitConvert(i *Convert)           { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitExtractValue(i *ExtractValue) { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitGEP(i *GEP)                   { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitICmp(i *ICmp)                 { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitInvoke(i *Invoke)             { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitLandingPad(i *LandingPad)     { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitLoad(i *Load)                 { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitPhi(i *Phi)                   { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitRet(i *Ret)                   { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitStore(i *Store)               { v.VisitInstr(i) }
+func (v DefaultVisitor) VisitUnreachable(i *Unreachable)   { v.VisitInstr(i) }
+
+func (v DefaultVisitor) VisitInstr(i Instruction) {
+	if v.Default != nil {
+		v.Default(i)
+	}
+}
+
+// VisitInstr calls the method of the visitor corresponding to the kind of the instruction.
+func VisitInstr(visitor Visitor, instr Instruction) {
+	switch i := instr.(type) {
+	case *Alloc:
+		visitor.VisitAlloc(i)
+	case *BinOp:
+		visitor.VisitBinOp(i)
+	case *Br:
+		visitor.VisitBr(i)
+	case *Call:
+		visitor.VisitCall(i)
+	case *CondBr:
+		visitor.VisitCondBr(i)
+	case *Convert:
+		visitor.VisitConvert(i)
+	case *ExtractValue:
+		visitor.VisitExtractValue(i)
+	case *GEP:
+		visitor.VisitGEP(i)
+	case *ICmp:
+		visitor.VisitICmp(i)
+	case *Invoke:
+		visitor.VisitInvoke(i)
+	case *LandingPad:
+		visitor.VisitLandingPad(i)
+	case *Load:
+		visitor.VisitLoad(i)
+	case *Phi:
+		visitor.VisitPhi(i)
+	case *Ret:
+		visitor.VisitRet(i)
+	case *Store:
+		visitor.VisitStore(i)
+	case *Unreachable:
+		visitor.VisitUnreachable(i)
+	default:
+		visitor.VisitInstr(i)
+	}
+}
@@ -0,0 +1,93 @@
+package ssa
+
+// BlockOrder returns the blocks of a function in the order they should be walked.
+type BlockOrder func(*Function) []*Block
+
+// LayoutOrder returns the blocks of the function in the order they appear in the function.
+func LayoutOrder(fn *Function) []*Block {
+	return fn.Blocks()
+}
+
+// ReversePostOrder returns the blocks reachable from the entry block in reverse post-order.
+// Every block appears before its successors, except when the edge between them is a back edge.
+// Blocks unreachable from the entry block are not included.
+func ReversePostOrder(fn *Function) []*Block {
+	if fn.IsPrototype() {
+		return nil
+	}
+
+	visited := make(map[*Block]bool, len(fn.blocks))
+	postOrder := make([]*Block, 0, len(fn.blocks))
+
+	var visit func(*Block)
+	visit = func(b *Block) {
+		visited[b] = true
+
+		for _, succ := range b.Successors() {
+			if !visited[succ] {
+				visit(succ)
+			}
+		}
+
+		postOrder = append(postOrder, b)
+	}
+	visit(fn.EntryBlock())
+
+	for i, j := 0, len(postOrder)-1; i < j; i, j = i+1, j-1 {
+		postOrder[i], postOrder[j] = postOrder[j], postOrder[i]
+	}
+
+	return postOrder
+}
+
+// Walk calls the visitor for every instruction in the module. Prototypes are skipped.
+// If order is nil, LayoutOrder is used.
+func Walk(visitor Visitor, mod *Module, order BlockOrder) {
+	for _, fn := range mod.Functions() {
+		WalkFunction(visitor, fn, order)
+	}
+}
+
+// WalkFunction calls the visitor for every instruction in the function, visiting blocks in the specified order.
+// If order is nil, LayoutOrder is used.
+func WalkFunction(visitor Visitor, fn *Function, order BlockOrder) {
+	if fn.IsPrototype() {
+		return
+	}
+
+	if order == nil {
+		order = LayoutOrder
+	}
+
+	if fnVisitor, ok := visitor.(FunctionVisitor); ok {
+		fnVisitor.VisitFunction(fn)
+	}
+
+	// copy, as the visitor may add or remove blocks
+	blocks := append([]*Block(nil), order(fn)...)
+	for _, block := range blocks {
+		if block.function != fn {
+			continue // block was removed by the visitor
+		}
+
+		WalkBlock(visitor, block)
+	}
+}
+
+// WalkBlock calls the visitor for every instruction in the block.
+// The visitor may erase the instruction it is visiting, or any other instruction.
+// Erased instructions are not visited, and instructions inserted during the walk are not visited.
+func WalkBlock(visitor Visitor, block *Block) {
+	if blockVisitor, ok := visitor.(BlockVisitor); ok {
+		blockVisitor.VisitBlock(block)
+	}
+
+	instrs := append([]Instruction(nil), block.instrs...)
+	for _, instr := range instrs {
+		if instr.Block() != block {
+			continue // instruction was erased or moved by the visitor
+		}
+
+		VisitInstr(visitor, instr)
+	}
+}
@@ -60,7 +60,7 @@ func (v allocator) valOffset(val ssa.Value) int {
 
 func (v allocator) valStr(val ssa.Value) string {
 	if global, ok := val.(*ssa.Global); ok {
-		return fmt.Sprintf("$" + global.Name())
+		return "$" + global.Name()
 	}
 
 	return fmt.Sprintf("-%d(#rbp)", v.valOffset(val))
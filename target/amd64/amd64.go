@@ -21,6 +21,9 @@ type Target struct {
 	mod *ssa.Module
 
 	labelID int64
+
+	eh    *functionEH // nil if the current function has no LSDA
+	modEH *moduleEH
 }
 
 func (v Target) Generate(out io.Writer, mod *ssa.Module) (err error) {
@@ -21,12 +21,11 @@ func (v Target) genSaveFunctionParameters(a *allocator, fn *ssa.Function) {
 	}
 }
 
-func (v Target) genLoadCallArguments(a *allocator, call *ssa.Call) {
-	ops := ssa.GetOperands(call)
+func (v Target) genLoadCallArguments(a *allocator, fn ssa.Value, args []ssa.Value) {
 	if v.Platform.IsUnixLike() {
-		v.sysVCopyFunctionVals(a, ops[1:], ops[0].Type().(*types.Signature), true)
+		v.sysVCopyFunctionVals(a, args, fn.Type().(*types.Signature), true)
 	} else if v.Platform == platform.Windows {
-		v.winLoadCallArguments(a, ops[1:], ops[0].Type().(*types.Signature))
+		v.winLoadCallArguments(a, args, fn.Type().(*types.Signature))
 	} else {
 		panic("unim")
 	}
@@ -0,0 +1,290 @@
+package amd64
+
+import (
+	"github.com/MovingtoMars/nnvm/ssa"
+)
+
+// Exception handling follows the Itanium C++ ABI: frames are described to the unwinder with .cfi_* directives,
+// and functions with a personality get a language-specific data area (LSDA) in .gcc_except_table which
+// maps call sites to landing pads. The LSDA and the DW.ref symbols it uses are written with ELF section
+// syntax, so they are only generated for Linux.
+//
+// The unwinder enters a landing pad with the exception object in %rax and the selector in %rdx. Each Invoke
+// gets its own entry point ("pad"), which stores these into the value of the LandingPad, translating the
+// selector into a clause index, and then moves the incoming values of any phis before jumping to the block.
+
+const (
+	dwEHPeOmit              = 0xff
+	dwEHPeUleb128           = 0x01
+	dwEHPePcrelSdata4       = 0x1b
+	dwEHPeIndirectPcrelData = 0x9b
+)
+
+type ehCallSite struct {
+	begin, end string      // labels around the call instruction
+	pad        string      // label of the entry point of the landing pad, empty for calls
+	invoke     *ssa.Invoke // nil for calls
+}
+
+type functionEH struct {
+	lsdaLabel string
+
+	// Every call in the function must be covered by a call site, or the personality will terminate the program
+	// when an exception unwinds through it.
+	callSites        []*ehCallSite
+	callSiteForInstr map[ssa.Instruction]*ehCallSite
+
+	typeInfos []string // symbol names, empty for catch-all; the filter of a type info is its index plus one
+}
+
+type moduleEH struct {
+	dwRefs    []string
+	dwRefSeen map[string]bool
+}
+
+func (v *moduleEH) dwRef(symbol string) string {
+	if !v.dwRefSeen[symbol] {
+		v.dwRefSeen[symbol] = true
+		v.dwRefs = append(v.dwRefs, symbol)
+	}
+
+	return "DW.ref." + symbol
+}
+
+func (v *Target) newFunctionEH(fn *ssa.Function) *functionEH {
+	eh := &functionEH{
+		lsdaLabel:        v.nextLabelName(),
+		callSiteForInstr: make(map[ssa.Instruction]*ehCallSite),
+	}
+
+	for _, block := range fn.Blocks() {
+		for _, instr := range block.Instrs() {
+			switch instr := instr.(type) {
+			case *ssa.Call:
+				eh.addCallSite(instr, &ehCallSite{begin: v.nextLabelName(), end: v.nextLabelName()})
+
+			case *ssa.Invoke:
+				eh.addCallSite(instr, &ehCallSite{
+					begin:  v.nextLabelName(),
+					end:    v.nextLabelName(),
+					pad:    v.nextLabelName(),
+					invoke: instr,
+				})
+
+				for _, symbol := range landingPadTypeInfos(blockLandingPad(instr.UnwindTarget())) {
+					eh.typeInfoFilter(symbol)
+				}
+			}
+		}
+	}
+
+	return eh
+}
+
+func (v *functionEH) addCallSite(instr ssa.Instruction, callSite *ehCallSite) {
+	v.callSites = append(v.callSites, callSite)
+	v.callSiteForInstr[instr] = callSite
+}
+
+func (v *functionEH) typeInfoFilter(symbol string) int {
+	for i, typeInfo := range v.typeInfos {
+		if typeInfo == symbol {
+			return i + 1
+		}
+	}
+
+	v.typeInfos = append(v.typeInfos, symbol)
+	return len(v.typeInfos)
+}
+
+func blockLandingPad(block *ssa.Block) *ssa.LandingPad {
+	for _, instr := range block.Instrs() {
+		if lp, ok := instr.(*ssa.LandingPad); ok {
+			return lp
+		}
+	}
+
+	panic("internal error: unwind target has no landing pad")
+}
+
+// Returns the type info symbols of the clauses of the landing pad, in selector order.
+func landingPadTypeInfos(lp *ssa.LandingPad) []string {
+	var symbols []string
+	for i := 0; i < lp.NumCatches(); i++ {
+		symbols = append(symbols, lp.Catch(i).Name())
+	}
+
+	if lp.IsCatchAll() {
+		symbols = append(symbols, "")
+	}
+
+	return symbols
+}
+
+// wcfi writes a CFI directive, if the platform uses them.
+func (v Target) wcfi(format string, args ...interface{}) {
+	if v.Platform.IsUnixLike() {
+		v.wop(format, args...)
+	}
+}
+
+// Without exception handling data, such as on platforms other than Linux, an Invoke is generated as a
+// call followed by a jump to the normal target, and its landing pad is never entered.
+func (v Target) genInvoke(a *allocator, instr *ssa.Invoke, blockLabelMap map[*ssa.Block]string) {
+	ops := ssa.GetOperands(instr)
+	v.genCallFunction(a, instr, ops[0], ops[1:len(ops)-2])
+
+	v.handleBrPhi(a, instr.Block(), instr.NormalTarget())
+	v.wop("jmp %s", blockLabelMap[instr.NormalTarget()])
+}
+
+func (v Target) genLandingPadEntries(a *allocator, blockLabelMap map[*ssa.Block]string) {
+	selectorOffset := newStructLayout(ssa.LandingPadType()).fieldOffsetBits(1) / 8
+
+	for _, callSite := range v.eh.callSites {
+		if callSite.invoke == nil {
+			continue
+		}
+
+		target := callSite.invoke.UnwindTarget()
+		lp := blockLandingPad(target)
+
+		v.wlabel(callSite.pad)
+		v.wop("movq #rax, %s", a.valStr(lp))
+
+		v.wop("xorl #ecx, #ecx")
+		for i, symbol := range landingPadTypeInfos(lp) {
+			v.wop("movl $%d, #r11d", i+1)
+			v.wop("cmpl $%d, #edx", v.eh.typeInfoFilter(symbol))
+			v.wop("cmovel #r11d, #ecx")
+		}
+		v.wop("movl #ecx, -%d(#rbp)", a.valOffset(lp)-selectorOffset)
+
+		v.handleBrPhi(a, callSite.invoke.Block(), target)
+		v.wop("jmp %s", blockLabelMap[target])
+	}
+}
+
+func sleb128Size(x int) int {
+	size := 1
+	for x < -64 || x >= 64 {
+		x >>= 7
+		size++
+	}
+	return size
+}
+
+func (v Target) genLSDA(fn *ssa.Function) {
+	ttBase := v.eh.lsdaLabel + "_tt"
+	ttBaseRef := v.eh.lsdaLabel + "_ttref"
+	csBegin := v.eh.lsdaLabel + "_csb"
+	csEnd := v.eh.lsdaLabel + "_cse"
+
+	v.wop(".section .gcc_except_table,\"a\",@progbits")
+	v.wop(".p2align 2")
+	v.wlabel(v.eh.lsdaLabel)
+
+	v.wop(".byte 0x%x", dwEHPeOmit) // @LPStart
+	if len(v.eh.typeInfos) > 0 {
+		v.wop(".byte 0x%x", dwEHPeIndirectPcrelData) // @TType
+		v.wop(".uleb128 %s-%s", ttBase, ttBaseRef)
+		v.wlabel(ttBaseRef)
+	} else {
+		v.wop(".byte 0x%x", dwEHPeOmit)
+	}
+
+	// Action records form a chain for each landing pad: one record per clause, then one for cleanup.
+	// Each record is the filter followed by the displacement to the next record, or 0 for the last one.
+	var actionFilters [][]int
+	actionOffsets := make(map[*ssa.LandingPad]int)
+	actionTableSize := 0
+
+	for _, callSite := range v.eh.callSites {
+		if callSite.invoke == nil {
+			continue
+		}
+
+		lp := blockLandingPad(callSite.invoke.UnwindTarget())
+		if _, ok := actionOffsets[lp]; ok {
+			continue
+		}
+
+		var filters []int
+		for _, symbol := range landingPadTypeInfos(lp) {
+			filters = append(filters, v.eh.typeInfoFilter(symbol))
+		}
+
+		if len(filters) == 0 {
+			actionOffsets[lp] = 0 // cleanup only
+			continue
+		} else if lp.IsCleanup() {
+			filters = append(filters, 0)
+		}
+
+		actionOffsets[lp] = actionTableSize + 1
+		for _, filter := range filters {
+			actionTableSize += sleb128Size(filter) + 1 // the displacement is always 1 or 0, which fit in one byte
+		}
+		actionFilters = append(actionFilters, filters)
+	}
+
+	v.wop(".byte 0x%x", dwEHPeUleb128) // call site encoding
+	v.wop(".uleb128 %s-%s", csEnd, csBegin)
+	v.wlabel(csBegin)
+	for _, callSite := range v.eh.callSites {
+		v.wop(".uleb128 %s-%s", callSite.begin, fn.Name())
+		v.wop(".uleb128 %s-%s", callSite.end, callSite.begin)
+
+		if callSite.invoke == nil {
+			v.wop(".uleb128 0")
+			v.wop(".uleb128 0")
+		} else {
+			v.wop(".uleb128 %s-%s", callSite.pad, fn.Name())
+			v.wop(".uleb128 %d", actionOffsets[blockLandingPad(callSite.invoke.UnwindTarget())])
+		}
+	}
+	v.wlabel(csEnd)
+
+	for _, filters := range actionFilters {
+		for i, filter := range filters {
+			v.wop(".sleb128 %d", filter)
+			if i < len(filters)-1 {
+				v.wop(".sleb128 1")
+			} else {
+				v.wop(".sleb128 0")
+			}
+		}
+	}
+
+	if len(v.eh.typeInfos) > 0 {
+		v.wop(".p2align 2")
+		for i := len(v.eh.typeInfos) - 1; i >= 0; i-- {
+			if symbol := v.eh.typeInfos[i]; symbol == "" {
+				v.wop(".long 0") // catch-all
+			} else {
+				v.wop(".long %s-.", v.modEH.dwRef(symbol))
+			}
+		}
+		v.wlabel(ttBase)
+	}
+
+	v.wop(".text")
+}
+
+// The personality and type infos are referenced indirectly through DW.ref symbols, so the LSDA and CIE
+// don't need relocations against symbols which may be in other shared objects.
+func (v Target) genDWRefs() {
+	for _, symbol := range v.modEH.dwRefs {
+		ref := "DW.ref." + symbol
+
+		v.wnl()
+		v.wop(".hidden %s", ref)
+		v.wop(".weak %s", ref)
+		v.wop(".section .data.%s,\"awG\",@progbits,%s,comdat", ref, ref)
+		v.wop(".p2align 3")
+		v.wop(".type %s,@object", ref)
+		v.wop(".size %s, 8", ref)
+		v.wlabel(ref)
+		v.wop(".quad %s", symbol)
+	}
+}
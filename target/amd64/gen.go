@@ -4,6 +4,7 @@ import (
 	"fmt"
 
 	"github.com/MovingtoMars/nnvm/ssa"
+	"github.com/MovingtoMars/nnvm/target/platform"
 	"github.com/MovingtoMars/nnvm/types"
 )
 
@@ -218,6 +219,8 @@ func (v Target) genArrayGlobal(global *ssa.Global) {
 }
 
 func (v *Target) gen() {
+	v.modEH = &moduleEH{dwRefSeen: make(map[string]bool)}
+
 	v.wop(".data")
 
 	v.genGlobals()
@@ -228,6 +231,8 @@ func (v *Target) gen() {
 	for _, fn := range v.mod.Functions() {
 		v.genFunction(fn)
 	}
+
+	v.genDWRefs()
 }
 
 func (v *Target) nextLabelName() string {
@@ -257,12 +262,30 @@ func (v *Target) genFunction(fn *ssa.Function) {
 		v.wop(".type %s,@function", fn.Name())
 	}
 
+	v.eh = nil
+	if v.Platform == platform.Linux && fn.Personality() != nil {
+		v.eh = v.newFunctionEH(fn)
+	}
+
 	v.wlabel(fn.Name())
 
+	v.wcfi(".cfi_startproc")
+	if v.eh != nil {
+		v.wcfi(".cfi_personality 0x%x, %s", dwEHPeIndirectPcrelData, v.modEH.dwRef(fn.Personality().Name()))
+		v.wcfi(".cfi_lsda 0x%x, %s", dwEHPePcrelSdata4, v.eh.lsdaLabel)
+	}
+
 	v.wop("pushq #rbp")
+	v.wcfi(".cfi_def_cfa_offset 16")
+	v.wcfi(".cfi_offset #rbp, -16")
 	v.wop("pushq #rbx")
+	v.wcfi(".cfi_def_cfa_offset 24")
+	v.wcfi(".cfi_offset #rbx, -24")
 	v.wop("pushq #r15")
+	v.wcfi(".cfi_def_cfa_offset 32")
+	v.wcfi(".cfi_offset #r15, -32")
 	v.wop("movq #rsp, #rbp")
+	v.wcfi(".cfi_def_cfa_register #rbp")
 	v.wop("subq $%d, #rsp", (allocator.stackSize|0xF)+1)
 
 	retType := fn.Type().(*types.Signature).ReturnType()
@@ -282,4 +305,14 @@ func (v *Target) genFunction(fn *ssa.Function) {
 		}
 	}
 
+	if v.eh != nil {
+		v.genLandingPadEntries(allocator, blockLabelMap)
+	}
+
+	v.wcfi(".cfi_endproc")
+
+	if v.eh != nil {
+		v.genLSDA(fn)
+	}
+
 }
@@ -22,6 +22,12 @@ func (v Target) genInstr(a *allocator, instr ssa.Instruction, blockLabelMap map[
 		v.genCondBr(a, instr, blockLabelMap)
 	case *ssa.Call:
 		v.genCall(a, instr)
+	case *ssa.Invoke:
+		v.genInvoke(a, instr, blockLabelMap)
+	case *ssa.LandingPad:
+		// do nothing, handled by the landing pad entry of each invoke
+	case *ssa.ExtractValue:
+		v.genExtractValue(a, instr)
 	case *ssa.Convert:
 		v.genConvert(a, instr)
 	case *ssa.GEP:
@@ -41,6 +47,7 @@ func (v Target) genInstr(a *allocator, instr ssa.Instruction, blockLabelMap map[
 	}
 }
 
+// Loads and stores are always emitted as written, so volatile accesses are never folded or dropped.
 func (v Target) genLoad(a *allocator, instr *ssa.Load) {
 	v.moveIntToReg(a, ssa.GetOperands(instr)[0], "r11")
 	v.moveMemToMem("r11", "rbp", 0, -a.valOffset(instr), TypeStoreSizeInBits(instr.Type())/8)
@@ -52,8 +59,27 @@ func (v Target) genStore(a *allocator, instr *ssa.Store) {
 	v.moveMemToMem("rbp", "r11", -a.valOffset(ops[1]), 0, TypeStoreSizeInBits(ops[1].Type())/8)
 }
 
+// %rsp is kept 16-byte aligned, so calls can be made after an Alloc without realigning the stack.
 func (v Target) genAlloc(a *allocator, instr *ssa.Alloc) {
-	v.wop("subq $%d, #rsp", TypeStoreSizeInBits(instr.Type())/8)
+	elem := instr.Type().(*types.Pointer).Element()
+	size := TypeStoreSizeInBits(elem) / 8
+
+	if count := ssa.GetOperands(instr)[0]; count == nil {
+		v.wop("subq $%d, #rsp", (size+15)&^15)
+	} else if lit, ok := count.(*ssa.IntLiteral); ok {
+		v.wop("subq $%d, #rsp", (size*int(lit.LiteralValue().(uint64))+15)&^15)
+	} else {
+		v.moveIntToReg(a, count, "rax")
+		v.wop("imulq $%d, #rax", size)
+		v.wop("addq $15, #rax")
+		v.wop("andq $-16, #rax")
+		v.wop("subq #rax, #rsp")
+	}
+
+	if align := instr.Align(); align > 16 {
+		v.wop("andq $-%d, #rsp", align)
+	}
+
 	v.wop("movq #rsp, %s", a.valStr(instr))
 }
 
@@ -110,17 +136,31 @@ func (v Target) handleGEPPointerOrArray(a *allocator, index ssa.Value, storesz i
 }
 
 func (v Target) genCall(a *allocator, instr *ssa.Call) {
-	v.genLoadCallArguments(a, instr)
-	v.wop("call %s", ssa.GetOperands(instr)[0].Name())
+	ops := ssa.GetOperands(instr)
+	v.genCallFunction(a, instr, ops[0], ops[1:])
+}
+
+// Generates the call of a Call or Invoke, storing the return value into the value of the instruction.
+func (v Target) genCallFunction(a *allocator, instr ssa.Instruction, fn ssa.Value, args []ssa.Value) {
+	v.genLoadCallArguments(a, fn, args)
+
+	if v.eh != nil {
+		callSite := v.eh.callSiteForInstr[instr]
+		v.wlabel(callSite.begin)
+		v.wop("call %s", fn.Name())
+		v.wlabel(callSite.end)
+	} else {
+		v.wop("call %s", fn.Name())
+	}
 
 	if v.Platform == platform.Windows { // TODO move this
-		totalMem := winTotalMemSizeBits(ssa.GetOperands(instr)[0].Type().(*types.Signature).Parameters())
+		totalMem := winTotalMemSizeBits(fn.Type().(*types.Signature).Parameters())
 		if totalMem > 0 {
 			v.wop("addq $%d, #rsp", totalMem/8)
 		}
 	}
 
-	v.genSaveReturnValue(a, instr)
+	v.genSaveReturnValue(a, instr.(ssa.Value))
 }
 
 func (v Target) genConvert(a *allocator, instr *ssa.Convert) {
@@ -321,11 +361,33 @@ func (v Target) genRet(a *allocator, instr *ssa.Ret) {
 	retVal := ssa.GetOperands(instr)[0]
 	v.genLoadReturnValue(a, retVal)
 
+	v.wcfi(".cfi_remember_state")
 	v.wop("movq #rbp, #rsp")
+	v.wcfi(".cfi_def_cfa_register #rsp")
 	v.wop("popq #r15")
+	v.wcfi(".cfi_def_cfa_offset 24")
 	v.wop("popq #rbx")
+	v.wcfi(".cfi_def_cfa_offset 16")
 	v.wop("popq #rbp")
+	v.wcfi(".cfi_def_cfa_offset 8")
 	v.wop("retq")
+	v.wcfi(".cfi_restore_state")
+}
+
+func (v Target) genExtractValue(a *allocator, instr *ssa.ExtractValue) {
+	op := ssa.GetOperands(instr)[0]
+
+	offset := 0
+	switch typ := op.Type().(type) {
+	case *types.Struct:
+		offset = newStructLayout(typ).fieldOffsetBits(instr.Index()) / 8
+	case *types.Array:
+		offset = instr.Index() * TypeStoreSizeInBits(typ.Element()) / 8
+	default:
+		panic("unim")
+	}
+
+	v.moveMemToMem("rbp", "rbp", -a.valOffset(op)+offset, -a.valOffset(instr), TypeStoreSizeInBits(instr.Type())/8)
 }
 
 var (
@@ -136,3 +136,14 @@ func TypeAlignmentInBits(typ types.Type) int {
 
 	return i
 }
+
+// DataLayout gives the sizes and field offsets of types on amd64. It implements analysis.DataLayout.
+type DataLayout struct{}
+
+func (_ DataLayout) TypeStoreSizeInBits(typ types.Type) int {
+	return TypeStoreSizeInBits(typ)
+}
+
+func (_ DataLayout) StructFieldOffsetInBits(typ *types.Struct, index int) int {
+	return newStructLayout(typ).fieldOffsetBits(index)
+}